@@ -2,6 +2,7 @@ package app
 
 import (
 	"GoWeb/config"
+	"GoWeb/mail"
 	"database/sql"
 	"embed"
 )
@@ -10,6 +11,8 @@ import (
 type App struct {
 	Config         config.Configuration // Configuration file
 	Db             *sql.DB              // Database connection
+	Replicas       []*sql.DB            // Read replica connections, empty when no replicas are configured
 	Res            *embed.FS            // Resources from the embedded filesystem
+	Mailer         mail.Mailer          // Delivers email, chosen by MailDriver
 	ScheduledTasks Scheduled            // Scheduled contains a struct of all scheduled functions
 }
@@ -8,18 +8,65 @@ import (
 )
 
 type Configuration struct {
+	Environment string `json:"Environment"` // Deployment environment such as development, testing or production
+	LogLevel    string `json:"LogLevel"`    // Minimum level written to the log file: DEBUG, INFO, WARN or ERROR
+	BaseUrl     string `json:"BaseUrl"`     // Public URL of the site used in emailed links, e.g. https://example.com
+	SiteName    string `json:"SiteName"`    // Name authenticator apps list two-factor codes under, defaults to GoWeb
+
 	Db struct {
-		Ip          string `json:"DbIp"`
-		Port        string `json:"DbPort"`
-		Name        string `json:"DbName"`
-		User        string `json:"DbUser"`
-		Password    string `json:"DbPassword"`
-		AutoMigrate bool   `json:"DbAutoMigrate"`
+		Ip           string   `json:"DbIp"`
+		Port         string   `json:"DbPort"`
+		Name         string   `json:"DbName"`
+		User         string   `json:"DbUser"`
+		Password     string   `json:"DbPassword"`
+		AutoMigrate  bool     `json:"DbAutoMigrate"`
+		QueryTimeout int      `json:"DbQueryTimeout"` // Seconds before a single query is cancelled, 0 disables the timeout
+		Replicas     []string `json:"DbReplicas"`     // Connection strings of read replicas, reads are spread across them
+		Schema       string   `json:"DbSchema"`       // Schema holding the tables, defaults to public
+		Dialect      string   `json:"DbDialect"`      // postgres, sqlite or mysql, defaults to postgres
+		DriverName   string   `json:"DbDriverName"`   // Overrides the database/sql driver registered for the dialect
+
+		SlowQueryThreshold   int `json:"DbSlowQueryThreshold"`   // Milliseconds after which a query is logged as slow, 0 disables
+		MaxQueriesPerRequest int `json:"DbMaxQueriesPerRequest"` // Requests running more queries are logged as possible N+1, 0 disables
+
+		SearchLanguage string `json:"DbSearchLanguage"` // Postgres text search configuration, e.g. english, defaults to english
+
+		MigrationLockTimeout int `json:"DbMigrationLockTimeout"` // Seconds to wait for another instance to finish migrating, defaults to 60
+	}
+
+	Tenancy struct {
+		Enabled bool     `json:"TenancyEnabled"` // Give every tenant its own schema and route requests to it
+		Mode    string   `json:"TenancyMode"`    // How requests name their tenant: "subdomain" or "header"
+		Header  string   `json:"TenancyHeader"`  // Header naming the tenant in header mode, defaults to X-Tenant
+		Tenants []string `json:"Tenants"`        // Tenant names, lowercase letters, digits and underscores
+	}
+
+	Encryption struct {
+		Keys       map[string]string `json:"EncryptionKeys"`       // Key IDs mapped to base64 encoded 32 byte AES-256 keys
+		CurrentKey string            `json:"EncryptionCurrentKey"` // ID of the key new values are encrypted with
+	}
+
+	Mail struct {
+		Driver   string `json:"MailDriver"` // "log" writes emails to the log, "smtp" sends them, defaults to log
+		Host     string `json:"MailHost"`
+		Port     string `json:"MailPort"` // Defaults to 587
+		Username string `json:"MailUsername"`
+		Password string `json:"MailPassword"`
+		From     string `json:"MailFrom"`
+	}
+
+	Login struct {
+		MaxAttempts      int `json:"LoginMaxAttempts"`      // Failed logins of a username before it is locked out, defaults to 5
+		MaxAttemptsPerIp int `json:"LoginMaxAttemptsPerIp"` // Failed logins from an IP address before it is locked out, defaults to 20
+		AttemptWindow    int `json:"LoginAttemptWindow"`    // Seconds failed logins are counted over, defaults to 900
+		LockoutDuration  int `json:"LoginLockoutDuration"`  // Seconds a locked out username or IP address waits after its last failure, defaults to 900
 	}
 
 	Listen struct {
-		Ip   string `json:"HttpIp"`
-		Port string `json:"HttpPort"`
+		Ip             string `json:"HttpIp"`
+		Port           string `json:"HttpPort"`
+		ClientIpHeader string `json:"HttpClientIpHeader"` // Header reverse proxies add the client address to, e.g. X-Forwarded-For, empty uses the connection address
+		Proxies        int    `json:"HttpProxies"`        // Number of reverse proxies appending to HttpClientIpHeader, defaults to 1
 	}
 
 	Template struct {
@@ -40,7 +87,7 @@ func LoadConfig() Configuration {
 	defer func(file *os.File) {
 		err := file.Close()
 		if err != nil {
-			slog.Error("unable to close JSON config file: ", err)
+			slog.Error("unable to close JSON config file: " + err.Error())
 		}
 	}(file)
 
@@ -5,6 +5,11 @@ import (
 	"GoWeb/models"
 	"GoWeb/security"
 	"GoWeb/templating"
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
 	"net/http"
 )
 
@@ -13,12 +18,14 @@ type Get struct {
 	App *app.App
 }
 
-func (g *Get) ShowHome(w http.ResponseWriter, _ *http.Request) {
+func (g *Get) ShowHome(w http.ResponseWriter, r *http.Request) {
 	type dataStruct struct {
+		templating.Page
 		Test string
 	}
 
 	data := dataStruct{
+		Page: pageFor(g.App, r),
 		Test: "Hello World!",
 	}
 
@@ -45,6 +52,8 @@ func (g *Get) ShowRegister(w http.ResponseWriter, r *http.Request) {
 func (g *Get) ShowLogin(w http.ResponseWriter, r *http.Request) {
 	type dataStruct struct {
 		CsrfToken string
+		Invalid   bool
+		Locked    bool
 	}
 
 	CsrfToken, err := security.GenerateCsrfToken(w, r)
@@ -54,12 +63,280 @@ func (g *Get) ShowLogin(w http.ResponseWriter, r *http.Request) {
 
 	data := dataStruct{
 		CsrfToken: CsrfToken,
+		Invalid:   r.URL.Query().Get("invalid") != "",
+		Locked:    r.URL.Query().Get("locked") != "",
 	}
 
 	templating.RenderTemplate(w, "templates/pages/login.html", data)
 }
 
+func (g *Get) ShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	type dataStruct struct {
+		CsrfToken string
+		Sent      bool
+	}
+
+	CsrfToken, err := security.GenerateCsrfToken(w, r)
+	if err != nil {
+		return
+	}
+
+	data := dataStruct{
+		CsrfToken: CsrfToken,
+		Sent:      r.URL.Query().Get("sent") != "",
+	}
+
+	templating.RenderTemplate(w, "templates/pages/forgot_password.html", data)
+}
+
+func (g *Get) ShowResetPassword(w http.ResponseWriter, r *http.Request) {
+	type dataStruct struct {
+		CsrfToken string
+		Token     string
+		Invalid   bool
+	}
+
+	CsrfToken, err := security.GenerateCsrfToken(w, r)
+	if err != nil {
+		return
+	}
+
+	data := dataStruct{
+		CsrfToken: CsrfToken,
+		Token:     r.URL.Query().Get("token"),
+		Invalid:   r.URL.Query().Get("invalid") != "",
+	}
+
+	templating.RenderTemplate(w, "templates/pages/reset_password.html", data)
+}
+
+// VerifyEmail confirms the email address a verification link was sent to, without a token it shows the logged-in
+// user how to get a new link
+func (g *Get) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token != "" {
+		_, err := models.VerifyEmail(r.Context(), g.App, token)
+		if err == nil {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		if !errors.Is(err, models.ErrInvalidToken) {
+			slog.Error("error verifying email address: " + err.Error())
+		}
+	}
+
+	type dataStruct struct {
+		CsrfToken string
+		Email     string
+		LoggedIn  bool
+		Invalid   bool
+		Sent      bool
+		Throttled bool
+	}
+
+	CsrfToken, err := security.GenerateCsrfToken(w, r)
+	if err != nil {
+		return
+	}
+
+	user, err := models.CurrentUser(g.App, r)
+	if err == nil && user.Verified() {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	data := dataStruct{
+		CsrfToken: CsrfToken,
+		Email:     user.Email.String,
+		LoggedIn:  err == nil,
+		Invalid:   token != "",
+		Sent:      r.URL.Query().Get("sent") != "",
+		Throttled: r.URL.Query().Get("throttled") != "",
+	}
+
+	templating.RenderTemplate(w, "templates/pages/verify_email.html", data)
+}
+
+// ShowTwoFactorLogin asks for the code of a login waiting for its second factor
+func (g *Get) ShowTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	type dataStruct struct {
+		CsrfToken string
+		Invalid   bool
+	}
+
+	CsrfToken, err := security.GenerateCsrfToken(w, r)
+	if err != nil {
+		return
+	}
+
+	data := dataStruct{
+		CsrfToken: CsrfToken,
+		Invalid:   r.URL.Query().Get("invalid") != "",
+	}
+
+	templating.RenderTemplate(w, "templates/pages/two_factor_login.html", data)
+}
+
+// ShowTwoFactor lets the logged-in user set up an authenticator app, or manage two-factor authentication once it
+// is enabled
+func (g *Get) ShowTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type dataStruct struct {
+		templating.Page
+		CsrfToken string
+		Enabled   bool
+		Enrolling bool
+		Uri       template.URL
+		Secret    string
+		Invalid   bool
+	}
+
+	CsrfToken, err := security.GenerateCsrfToken(w, r)
+	if err != nil {
+		return
+	}
+
+	user, err := models.CurrentUser(g.App, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	data := dataStruct{
+		Page:      pageOf(r.Context(), g.App, user),
+		CsrfToken: CsrfToken,
+		Enabled:   user.TwoFactorEnabled(),
+		Invalid:   r.URL.Query().Get("invalid") != "",
+	}
+
+	uri, ok := models.TwoFactorEnrollmentUri(g.App, user)
+	if ok { // The secret is created by the setup form, showing the page mustn't replace it
+		data.Enrolling = true
+		data.Uri = template.URL(uri) // otpauth isn't a scheme templates trust in links
+		data.Secret = string(user.TotpSecret)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	templating.RenderTemplate(w, "templates/pages/two_factor.html", data)
+}
+
+// ShowSessions lists the devices the logged-in user is logged in on, with buttons to log them out
+func (g *Get) ShowSessions(w http.ResponseWriter, r *http.Request) {
+	type sessionData struct {
+		models.Session
+		Current bool
+	}
+
+	type dataStruct struct {
+		templating.Page
+		CsrfToken string
+		Sessions  []sessionData
+		Invalid   bool
+		Locked    bool
+	}
+
+	CsrfToken, err := security.GenerateCsrfToken(w, r)
+	if err != nil {
+		return
+	}
+
+	current, err := models.CurrentSession(g.App, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	sessions, err := models.ActiveSessions(r.Context(), g.App, current.UserId)
+	if err != nil {
+		slog.Error("error listing sessions: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := dataStruct{
+		Page:      pageFor(g.App, r),
+		CsrfToken: CsrfToken,
+		Invalid:   r.URL.Query().Get("invalid") != "",
+		Locked:    r.URL.Query().Get("locked") != "",
+	}
+	for _, session := range sessions {
+		data.Sessions = append(data.Sessions, sessionData{Session: session, Current: session.Id == current.Id})
+	}
+
+	templating.RenderTemplate(w, "templates/pages/sessions.html", data)
+}
+
 func (g *Get) Logout(w http.ResponseWriter, r *http.Request) {
 	models.LogoutUser(g.App, w, r)
 	http.Redirect(w, r, "/", http.StatusFound)
 }
+
+// ShowAdmin shows the tools for administrators, the route only lets users allowed to manage users through
+func (g *Get) ShowAdmin(w http.ResponseWriter, r *http.Request) {
+	type dataStruct struct {
+		templating.Page
+		CsrfToken string
+		Reset     bool
+		Unknown   bool
+	}
+
+	CsrfToken, err := security.GenerateCsrfToken(w, r)
+	if err != nil {
+		return
+	}
+
+	data := dataStruct{
+		Page:      pageFor(g.App, r),
+		CsrfToken: CsrfToken,
+		Reset:     r.URL.Query().Get("reset") != "",
+		Unknown:   r.URL.Query().Get("unknown") != "",
+	}
+
+	templating.RenderTemplate(w, "templates/pages/admin.html", data)
+}
+
+// Health is the readiness probe, it only tells whether the database answers with status 503 when the app shouldn't
+// receive traffic. Details such as errors, pool statistics and pending migrations are logged by the scheduled health
+// report instead of being served to anyone who asks
+func (g *Get) Health(w http.ResponseWriter, r *http.Request) {
+	type healthStruct struct {
+		Healthy bool `json:"healthy"`
+	}
+
+	health := healthStruct{Healthy: models.DatabaseReachable(r.Context(), g.App)}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !health.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	err := json.NewEncoder(w).Encode(health)
+	if err != nil {
+		slog.Error("error encoding health: " + err.Error())
+	}
+}
+
+// pageFor returns the page data showing templates who is logged in, empty for visitors who aren't
+func pageFor(app *app.App, r *http.Request) templating.Page {
+	user, err := models.CurrentUser(app, r)
+	if err != nil {
+		return templating.Page{}
+	}
+
+	return pageOf(r.Context(), app, user)
+}
+
+// pageOf returns the page data showing templates the logged-in user and what their roles let them do
+func pageOf(ctx context.Context, app *app.App, user models.User) templating.Page {
+	err := user.LoadAccess(ctx, app)
+	if err != nil {
+		slog.Error("error loading roles of user: " + err.Error())
+	}
+
+	return templating.Page{Viewer: &templating.Viewer{
+		Username:  user.Username,
+		TwoFactor: models.TwoFactorAvailable(),
+		Can:       func(permission string) bool { return models.Can(user, permission) },
+		HasRole:   user.HasRole,
+	}}
+}
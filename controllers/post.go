@@ -3,11 +3,20 @@ package controllers
 import (
 	"GoWeb/app"
 	"GoWeb/models"
+	"GoWeb/templating"
+	"context"
+	"database/sql"
+	"errors"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
+// backgroundTimeout bounds work handed off by afterResponse, such as talking to a slow mail server
+const backgroundTimeout = time.Minute
+
 // Post is a wrapper struct for the App struct
 type Post struct {
 	App *app.App
@@ -20,11 +29,23 @@ func (p *Post) Login(w http.ResponseWriter, r *http.Request) {
 
 	if username == "" || password == "" {
 		http.Redirect(w, r, "/login", http.StatusUnauthorized)
+		return
 	}
 
-	_, err := models.AuthenticateUser(p.App, w, username, password, remember)
-	if err != nil {
-		http.Redirect(w, r, "/login", http.StatusUnauthorized)
+	_, err := models.AuthenticateUser(r.Context(), p.App, w, username, password, remember, models.ClientOf(p.App, r))
+	switch {
+	case errors.Is(err, models.ErrTwoFactorRequired):
+		http.Redirect(w, r, "/two-factor-login", http.StatusFound)
+		return
+	case errors.Is(err, models.ErrTooManyLoginAttempts):
+		http.Redirect(w, r, "/login?locked=1", http.StatusFound)
+		return
+	case errors.Is(err, models.ErrInvalidCredentials):
+		http.Redirect(w, r, "/login?invalid=1", http.StatusFound)
+		return
+	case err != nil:
+		slog.Error("error authenticating user: " + err.Error())
+		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
 
@@ -33,20 +54,312 @@ func (p *Post) Login(w http.ResponseWriter, r *http.Request) {
 
 func (p *Post) Register(w http.ResponseWriter, r *http.Request) {
 	username := r.FormValue("username")
+	email := r.FormValue("email")
 	password := r.FormValue("password")
-	createdAt := time.Now()
-	updatedAt := time.Now()
 
-	if username == "" || password == "" {
+	if username == "" || email == "" || password == "" {
 		http.Redirect(w, r, "/register", http.StatusUnauthorized)
+		return
 	}
 
-	_, err := models.CreateUser(p.App, username, password, createdAt, updatedAt)
+	user, err := models.CreateUser(r.Context(), p.App, username, email, password)
 	if err != nil {
-		// TODO: if err == bcrypt.ErrPasswordTooLong display error to user, this will require a flash message system with cookies
+		// TODO: display ErrUsernameTaken, ErrEmailTaken and ErrInvalidEmail to the user, this will require a flash message system with cookies
 		slog.Error("error creating user: " + err.Error())
-		http.Redirect(w, r, "/register", http.StatusInternalServerError)
+		http.Redirect(w, r, "/register", http.StatusFound)
+		return
+	}
+
+	// The user can ask for another one once logged in if this fails
+	afterResponse(r, "sending verification email", func(ctx context.Context) error {
+		return models.SendEmailVerification(ctx, p.App, user)
+	})
+
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// ResendVerification sends the logged-in user another email verification link, at most one every few minutes
+func (p *Post) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	user, err := models.CurrentUser(p.App, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	err = models.SendEmailVerification(r.Context(), p.App, user)
+	switch {
+	case errors.Is(err, models.ErrAlreadyVerified):
+		http.Redirect(w, r, "/", http.StatusFound)
+	case errors.Is(err, models.ErrVerificationThrottled):
+		http.Redirect(w, r, "/verify-email?throttled=1", http.StatusFound)
+	case err != nil:
+		slog.Error("error sending verification email: " + err.Error())
+		http.Redirect(w, r, "/verify-email", http.StatusFound)
+	default:
+		http.Redirect(w, r, "/verify-email?sent=1", http.StatusFound)
+	}
+}
+
+func (p *Post) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	login := r.FormValue("login")
+
+	// Answer the same way and just as fast whether or not the user exists
+	if login != "" {
+		afterResponse(r, "requesting password reset", func(ctx context.Context) error {
+			return models.RequestPasswordReset(ctx, p.App, login)
+		})
+	}
+
+	http.Redirect(w, r, "/forgot-password?sent=1", http.StatusFound)
+}
+
+func (p *Post) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+
+	if token == "" || password == "" {
+		http.Redirect(w, r, "/reset-password?invalid=1&token="+url.QueryEscape(token), http.StatusFound)
+		return
+	}
+
+	err := models.ResetPassword(r.Context(), p.App, token, password)
+	if errors.Is(err, models.ErrInvalidToken) {
+		http.Redirect(w, r, "/forgot-password", http.StatusFound)
+		return
+	}
+	if err != nil {
+		slog.Error("error resetting password: " + err.Error())
+		http.Redirect(w, r, "/reset-password?invalid=1&token="+url.QueryEscape(token), http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// TwoFactorLogin finishes a login waiting for a code from the authenticator app or a recovery code
+func (p *Post) TwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	_, err := models.CompleteTwoFactorLogin(r.Context(), p.App, w, r, r.FormValue("code"))
+	switch {
+	case errors.Is(err, models.ErrInvalidTwoFactorCode):
+		http.Redirect(w, r, "/two-factor-login?invalid=1", http.StatusFound)
+	case errors.Is(err, models.ErrTooManyLoginAttempts):
+		http.Redirect(w, r, "/login?locked=1", http.StatusFound)
+	case errors.Is(err, models.ErrInvalidToken):
+		http.Redirect(w, r, "/login", http.StatusFound)
+	case err != nil:
+		slog.Error("error completing two-factor login: " + err.Error())
+		http.Redirect(w, r, "/login", http.StatusFound)
+	default:
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+// SetupTwoFactor gives the logged-in user an authenticator secret to add to their app, the two-factor page then shows it
+func (p *Post) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := models.CurrentUser(p.App, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	_, err = models.BeginTwoFactorEnrollment(r.Context(), p.App, &user)
+	if err != nil && !errors.Is(err, models.ErrTwoFactorEnabled) {
+		slog.Error("error beginning two-factor enrollment: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/two-factor", http.StatusFound)
+}
+
+// EnableTwoFactor confirms the authenticator app of the logged-in user works and shows their recovery codes
+func (p *Post) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := models.CurrentUser(p.App, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	codes, err := models.EnableTwoFactor(r.Context(), p.App, &user, r.FormValue("code"))
+	if err != nil {
+		if !errors.Is(err, models.ErrInvalidTwoFactorCode) {
+			slog.Error("error enabling two-factor authentication: " + err.Error())
+		}
+		http.Redirect(w, r, "/two-factor?invalid=1", http.StatusFound)
+		return
+	}
+
+	renderRecoveryCodes(w, codes)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the logged-in user and shows the new ones
+func (p *Post) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, err := models.CurrentUser(p.App, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	codes, err := models.RegenerateRecoveryCodes(r.Context(), p.App, &user, r.FormValue("code"))
+	if err != nil {
+		if !errors.Is(err, models.ErrInvalidTwoFactorCode) {
+			slog.Error("error regenerating recovery codes: " + err.Error())
+		}
+		http.Redirect(w, r, "/two-factor?invalid=1", http.StatusFound)
+		return
+	}
+
+	renderRecoveryCodes(w, codes)
+}
+
+// DisableTwoFactor turns off two-factor authentication of the logged-in user
+func (p *Post) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := models.CurrentUser(p.App, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	err = models.DisableTwoFactor(r.Context(), p.App, &user, r.FormValue("code"))
+	if err != nil {
+		if !errors.Is(err, models.ErrInvalidTwoFactorCode) {
+			slog.Error("error disabling two-factor authentication: " + err.Error())
+		}
+		http.Redirect(w, r, "/two-factor?invalid=1", http.StatusFound)
+		return
 	}
 
+	http.Redirect(w, r, "/two-factor", http.StatusFound)
+}
+
+// renderRecoveryCodes shows freshly generated recovery codes, they can't be shown again later
+func renderRecoveryCodes(w http.ResponseWriter, codes []string) {
+	type dataStruct struct {
+		Codes []string
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	templating.RenderTemplate(w, "templates/pages/recovery_codes.html", dataStruct{Codes: codes})
+}
+
+// RevokeSession logs the logged-in user out on one of their devices
+func (p *Post) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	current, err := models.CurrentSession(p.App, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Redirect(w, r, "/sessions", http.StatusFound)
+		return
+	}
+
+	if id == current.Id {
+		models.LogoutUser(p.App, w, r)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	err = models.RevokeSession(r.Context(), p.App, current.UserId, id)
+	if err != nil {
+		slog.Error("error revoking session: " + err.Error())
+	}
+
+	http.Redirect(w, r, "/sessions", http.StatusFound)
+}
+
+// RevokeOtherSessions logs the logged-in user out on every device except the one making the request
+func (p *Post) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	current, err := models.CurrentSession(p.App, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	err = models.RevokeOtherSessions(r.Context(), p.App, current.UserId, current.AuthToken)
+	if err != nil {
+		slog.Error("error revoking other sessions: " + err.Error())
+	}
+
+	http.Redirect(w, r, "/sessions", http.StatusFound)
+}
+
+// ChangePassword sets a new password for the logged-in user, which logs them out on every device
+func (p *Post) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user, err := models.CurrentUser(p.App, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	password := r.FormValue("password")
+	if password == "" {
+		http.Redirect(w, r, "/sessions?invalid=1", http.StatusFound)
+		return
+	}
+
+	err = models.ChangePassword(r.Context(), p.App, &user, r.FormValue("current_password"), password, models.ClientOf(p.App, r))
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		http.Redirect(w, r, "/sessions?invalid=1", http.StatusFound)
+		return
+	}
+	if errors.Is(err, models.ErrTooManyLoginAttempts) {
+		http.Redirect(w, r, "/sessions?locked=1", http.StatusFound)
+		return
+	}
+	if err != nil {
+		slog.Error("error changing password: " + err.Error())
+		http.Redirect(w, r, "/sessions?invalid=1", http.StatusFound)
+		return
+	}
+
+	models.LogoutUser(p.App, w, r) // The session is already revoked, this clears its cookie
 	http.Redirect(w, r, "/login", http.StatusFound)
 }
+
+// ResetUserTwoFactor turns off two-factor authentication for the named user who lost their device, for administrators
+func (p *Post) ResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	admin, err := models.CurrentUser(p.App, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	user, err := models.UserByUsername(r.Context(), p.App, r.FormValue("username"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Redirect(w, r, "/admin?unknown=1", http.StatusFound)
+		return
+	}
+	if err != nil {
+		slog.Error("error finding user: " + err.Error())
+		http.Redirect(w, r, "/admin", http.StatusFound)
+		return
+	}
+
+	err = models.ResetTwoFactor(r.Context(), p.App, &user)
+	if err != nil {
+		slog.Error("error resetting two-factor authentication: " + err.Error())
+		http.Redirect(w, r, "/admin", http.StatusFound)
+		return
+	}
+
+	slog.Warn("security: two-factor authentication reset by administrator", "user", user.Id, "admin", admin.Id)
+	http.Redirect(w, r, "/admin?reset=1", http.StatusFound)
+}
+
+// afterResponse runs f aside so the response doesn't wait for it, with the values of the request context such as the
+// tenant but without its cancellation. Use it for work whose duration would tell the client something, like whether
+// an email was sent
+func afterResponse(r *http.Request, action string, f func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), backgroundTimeout)
+	go func() {
+		defer cancel()
+
+		err := f(ctx)
+		if err != nil {
+			slog.Error("error " + action + ": " + err.Error())
+		}
+	}()
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"GoWeb/app"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Actions recorded in AuditLog
+const (
+	AuditInsert      = "insert"
+	AuditUpdate      = "update"
+	AuditDelete      = "delete"
+	AuditRestore     = "restore"
+	AuditForceDelete = "force_delete"
+)
+
+// Auditable is implemented by models whose inserts, updates and deletes are recorded in the AuditLog table, fields
+// tagged audit:"redact" are recorded as changed without their values
+type Auditable interface {
+	AuditEnabled() bool
+}
+
+// AuditLog is one recorded change of an Auditable model
+type AuditLog struct {
+	Id        int64
+	ActorId   sql.NullInt64 // User that made the change, null when it wasn't made by a logged-in user
+	Action    string        // One of the Audit* constants
+	TableName string
+	RowId     string
+	Changes   string // JSON object mapping each changed column to its "old" and "new" value
+	CreatedAt time.Time
+}
+
+// auditChange is the old and new value of a column, a side is omitted when the row didn't exist before or after
+type auditChange struct {
+	Old json.RawMessage `json:"old,omitempty"`
+	New json.RawMessage `json:"new,omitempty"`
+}
+
+type actorContextKey struct{}
+
+var redactedValue = json.RawMessage(`"[redacted]"`)
+
+// WithActor returns a context whose audited changes are attributed to the given user
+func WithActor(ctx context.Context, userId int64) context.Context {
+	return WithActorFunc(ctx, func() (int64, bool) { return userId, true })
+}
+
+// WithActorFunc returns a context whose audited changes are attributed to the user returned by resolve, it is only
+// called once the first audited change is made so requests that change nothing don't pay for the lookup
+func WithActorFunc(ctx context.Context, resolve func() (int64, bool)) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, sync.OnceValues(resolve))
+}
+
+// AuditHistory returns the recorded changes of the row of the model pointed to by ptr, oldest first
+func AuditHistory(ctx context.Context, app *app.App, ptr any) ([]AuditLog, error) {
+	value, m, err := modelValue(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !m.hasKey() {
+		return nil, fmt.Errorf("%s has no primary key to look up its history by", m.table)
+	}
+
+	return From[AuditLog](app).
+		Where("\"TableName\" = ? AND \"RowId\" = ?", m.table, m.rowId(value)).
+		OrderBy("\"Id\"").
+		All(ctx)
+}
+
+// AuditByActor returns the changes made by the given user, newest first
+func AuditByActor(ctx context.Context, app *app.App, userId int64, limit int) ([]AuditLog, error) {
+	return From[AuditLog](app).Where("\"ActorId\" = ?", userId).OrderBy("\"Id\" DESC").Limit(limit).All(ctx)
+}
+
+// isAudited reports whether changes to the model pointed to by ptr are recorded
+func isAudited(ptr any) bool {
+	auditable, ok := ptr.(Auditable)
+	return ok && auditable.AuditEnabled()
+}
+
+// recordAudit inserts the AuditLog row describing a change from before to after, either may be the zero Value when
+// the row didn't exist on that side of the change
+func recordAudit(ctx context.Context, app *app.App, m *model, action string, rowId string, before reflect.Value, after reflect.Value) error {
+	changes := make(map[string]auditChange)
+	for i, column := range m.columns {
+		field := m.fields[i]
+
+		var change auditChange
+		var err error
+		if before.IsValid() {
+			change.Old, err = json.Marshal(before.Field(field).Interface())
+			if err != nil {
+				return err
+			}
+		}
+		if after.IsValid() {
+			change.New, err = json.Marshal(after.Field(field).Interface())
+			if err != nil {
+				return err
+			}
+		}
+
+		if before.IsValid() && after.IsValid() && bytes.Equal(change.Old, change.New) {
+			continue
+		}
+
+		if m.redacted[field] {
+			if change.Old != nil {
+				change.Old = redactedValue
+			}
+			if change.New != nil {
+				change.New = redactedValue
+			}
+		}
+
+		changes[column] = change
+	}
+
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	entry := AuditLog{
+		Action:    action,
+		TableName: m.table,
+		RowId:     rowId,
+		Changes:   string(encoded),
+	}
+
+	if resolve, ok := ctx.Value(actorContextKey{}).(func() (int64, bool)); ok {
+		if actorId, found := resolve(); found {
+			entry.ActorId = sql.NullInt64{Int64: actorId, Valid: true}
+		}
+	}
+
+	return Insert(ctx, app, &entry)
+}
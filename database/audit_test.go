@@ -0,0 +1,152 @@
+package database_test
+
+import (
+	"GoWeb/database"
+	"GoWeb/testsupport"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Account is an audited test model with a redacted field
+type Account struct {
+	Id        int64
+	Name      string
+	Secret    string `audit:"redact"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt sql.NullTime
+}
+
+func (Account) AuditEnabled() bool {
+	return true
+}
+
+// Draft is a test model that opts out of auditing
+type Draft struct {
+	Id        int64
+	Name      string
+	DeletedAt sql.NullTime
+}
+
+func (Draft) AuditEnabled() bool {
+	return false
+}
+
+type auditChanges map[string]struct {
+	Old json.RawMessage `json:"old"`
+	New json.RawMessage `json:"new"`
+}
+
+func changesOf(t *testing.T, entry database.AuditLog) auditChanges {
+	t.Helper()
+
+	var changes auditChanges
+	err := json.Unmarshal([]byte(entry.Changes), &changes)
+	if err != nil {
+		t.Fatalf("error decoding changes %s: %v", entry.Changes, err)
+	}
+	return changes
+}
+
+func TestAuditRecordsDiffsOfEveryChange(t *testing.T) {
+	migrate(t, Account{
+		Id:        1,
+		Name:      "migrate",
+		Secret:    "migrate",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		DeletedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+	ctx, app := testsupport.Tx(t)
+	user := testsupport.NewUser(t, ctx, app)
+	ctx = database.WithActor(ctx, user.Id)
+
+	account := Account{Name: "before", Secret: "hunter2"}
+	err := database.Insert(ctx, app, &account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account.Name = "after"
+	account.Secret = "hunter3"
+	err = database.Update(ctx, app, &account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.Delete(ctx, app, &account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := database.AuditHistory(ctx, app, &account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions := []string{database.AuditInsert, database.AuditUpdate, database.AuditDelete}
+	if len(history) != len(actions) {
+		t.Fatalf("got %d audit entries, want %d", len(history), len(actions))
+	}
+	for i, entry := range history {
+		if entry.Action != actions[i] || entry.ActorId.Int64 != user.Id || !entry.ActorId.Valid {
+			t.Fatalf("entry %d: got %s by %v, want %s by user %d", i, entry.Action, entry.ActorId, actions[i], user.Id)
+		}
+	}
+
+	inserted := changesOf(t, history[0])
+	if string(inserted["Name"].New) != `"before"` || inserted["Name"].Old != nil {
+		t.Fatalf("insert recorded Name as %s -> %s, want only the new value", inserted["Name"].Old, inserted["Name"].New)
+	}
+
+	updated := changesOf(t, history[1])
+	if string(updated["Name"].Old) != `"before"` || string(updated["Name"].New) != `"after"` {
+		t.Fatalf("update recorded Name as %s -> %s, want before -> after", updated["Name"].Old, updated["Name"].New)
+	}
+	if string(updated["Secret"].Old) != `"[redacted]"` || string(updated["Secret"].New) != `"[redacted]"` {
+		t.Fatalf("update recorded Secret as %s -> %s, want it redacted", updated["Secret"].Old, updated["Secret"].New)
+	}
+	if _, ok := updated["CreatedAt"]; ok {
+		t.Fatal("update recorded the unchanged CreatedAt")
+	}
+
+	deleted := changesOf(t, history[2])
+	if _, ok := deleted["DeletedAt"]; !ok || len(deleted) != 1 {
+		t.Fatalf("soft delete recorded %s, want only DeletedAt", history[2].Changes)
+	}
+}
+
+func TestAuditSkipsModelsThatOptOut(t *testing.T) {
+	migrate(t, Draft{Id: 1, Name: "migrate", DeletedAt: sql.NullTime{Time: time.Now(), Valid: true}})
+	ctx, app := testsupport.Tx(t)
+
+	draft := Draft{Name: "draft"}
+	err := database.Insert(ctx, app, &draft)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	draft.Name = "changed"
+	err = database.Update(ctx, app, &draft)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := database.From[Draft](app).Where("\"Id\" = ?", draft.Id).Delete(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Fatalf("deleted %d drafts, want 1", deleted)
+	}
+
+	history, err := database.AuditHistory(ctx, app, &draft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("got %d audit entries of a model that opted out, want none", len(history))
+	}
+}
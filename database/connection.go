@@ -3,18 +3,17 @@ package database
 import (
 	"GoWeb/app"
 	"database/sql"
-	"fmt"
 	_ "github.com/lib/pq"
 	"log/slog"
 )
 
 // Connect returns a new database connection
 func Connect(app *app.App) *sql.DB {
-	postgresConfig := fmt.Sprintf("host=%s port=%s user=%s "+
-		"password=%s dbname=%s sslmode=disable",
-		app.Config.Db.Ip, app.Config.Db.Port, app.Config.Db.User, app.Config.Db.Password, app.Config.Db.Name)
+	if app.Config.Tenancy.Enabled && !DialectOf(app).SupportsSchemas() {
+		panic("multi-tenant mode requires a database dialect with schema support, " + DialectOf(app).Name() + " has none")
+	}
 
-	db, err := sql.Open("postgres", postgresConfig)
+	db, err := openInstrumented(app, driverName(app), connectionString(app))
 	if err != nil {
 		panic(err)
 	}
@@ -28,3 +27,8 @@ func Connect(app *app.App) *sql.DB {
 
 	return db
 }
+
+// connectionString builds the connection string of the primary database from the configuration
+func connectionString(app *app.App) string {
+	return DialectOf(app).ConnectionString(app)
+}
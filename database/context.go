@@ -0,0 +1,17 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"time"
+)
+
+// WithQueryTimeout returns a context derived from ctx that is cancelled once the configured per-query timeout
+// elapses, the returned cancel function should be deferred by the caller once the query results have been read
+func WithQueryTimeout(ctx context.Context, app *app.App) (context.Context, context.CancelFunc) {
+	if app.Config.Db.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, time.Duration(app.Config.Db.QueryTimeout)*time.Second)
+}
@@ -0,0 +1,368 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ConflictError is returned by Update when the row was changed by someone else since the model was read, it matches
+// ErrConflict with errors.Is. The caller should reload the row and reapply or reject the change
+type ConflictError struct {
+	Table   string
+	Id      any
+	Version int64 // Version the update expected to find
+}
+
+// ErrConflict is the sentinel matched by every ConflictError
+var ErrConflict = errors.New("row was modified concurrently")
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s with Id %v was modified concurrently, expected version %d", e.Table, e.Id, e.Version)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Insert creates a row from the model pointed to by ptr, CreatedAt and UpdatedAt are set automatically and the
+// stored row, including its generated primary key, is read back into the model. Version starts at 1
+func Insert(ctx context.Context, app *app.App, ptr any) error {
+	return write(ctx, app, ptr, AuditInsert, func(ctx context.Context, value reflect.Value, m *model) error {
+		return insertRow(ctx, app, value, m)
+	})
+}
+
+// Update saves every column of the model pointed to by ptr to the row with the same primary key, CreatedAt is left as stored
+// and UpdatedAt is set automatically. sql.ErrNoRows is returned if no such row exists. Models with a Version field
+// only update the row if its version still matches the model's, the version is then incremented and a
+// *ConflictError is returned when the row was changed in the meantime
+func Update(ctx context.Context, app *app.App, ptr any) error {
+	return write(ctx, app, ptr, AuditUpdate, func(ctx context.Context, value reflect.Value, m *model) error {
+		return updateRow(ctx, app, value, m)
+	})
+}
+
+// Delete removes the row of the model pointed to by ptr, models with a DeletedAt field are soft deleted by setting it
+// and are hidden from queries until restored. sql.ErrNoRows is returned if there was no row to delete
+func Delete(ctx context.Context, app *app.App, ptr any) error {
+	_, m, err := modelValue(ptr)
+	if err != nil {
+		return err
+	}
+
+	if !m.softDeletes() {
+		return ForceDelete(ctx, app, ptr)
+	}
+
+	return write(ctx, app, ptr, AuditDelete, func(ctx context.Context, value reflect.Value, m *model) error {
+		deletedAt := sql.NullTime{Time: time.Now(), Valid: true}
+		err := setDeletedAt(ctx, app, value, m, deletedAt, "\"DeletedAt\" IS NULL")
+		if err != nil {
+			return err
+		}
+
+		value.Field(m.deletedAt).Set(reflect.ValueOf(deletedAt))
+		return nil
+	})
+}
+
+// Restore brings back a soft deleted row of the model pointed to by ptr. sql.ErrNoRows is returned if the row isn't
+// soft deleted
+func Restore(ctx context.Context, app *app.App, ptr any) error {
+	return write(ctx, app, ptr, AuditRestore, func(ctx context.Context, value reflect.Value, m *model) error {
+		if !m.softDeletes() {
+			return errors.New(m.table + " does not support soft deletes")
+		}
+
+		err := setDeletedAt(ctx, app, value, m, sql.NullTime{}, "\"DeletedAt\" IS NOT NULL")
+		if err != nil {
+			return err
+		}
+
+		value.Field(m.deletedAt).Set(reflect.ValueOf(sql.NullTime{}))
+		return nil
+	})
+}
+
+// ForceDelete permanently removes the row of the model pointed to by ptr, even if the model supports soft deletes.
+// sql.ErrNoRows is returned if there was no row to delete
+func ForceDelete(ctx context.Context, app *app.App, ptr any) error {
+	return write(ctx, app, ptr, AuditForceDelete, func(ctx context.Context, value reflect.Value, m *model) error {
+		if !m.hasKey() {
+			return errors.New("delete requires " + m.table + " to have a primary key")
+		}
+
+		ctx, cancel := WithQueryTimeout(ctx, app)
+		defer cancel()
+
+		d := DialectOf(app)
+		query := "DELETE FROM " + m.qualifiedTable(ctx, app) + " WHERE " + m.keyCondition(d)
+		result, err := Writer(ctx, app).ExecContext(ctx, rebind(d, query), m.keyValues(value)...)
+		if err != nil {
+			return err
+		}
+
+		return expectAffected(result)
+	})
+}
+
+// write runs change against the model pointed to by ptr, when the model has hooks for the action or is Auditable the
+// change runs in a transaction together with the hooks and the audit entry describing it
+func write(ctx context.Context, app *app.App, ptr any, action string, change func(ctx context.Context, value reflect.Value, m *model) error) error {
+	value, m, err := modelValue(ptr)
+	if err != nil {
+		return err
+	}
+
+	beforeHook, afterHook := hooksOf(ptr, action)
+	audited := isAudited(ptr)
+	if !audited && beforeHook == nil && afterHook == nil {
+		return change(ctx, value, m)
+	}
+
+	return WithTransaction(ctx, app, func(ctx context.Context) error {
+		if beforeHook != nil {
+			err = beforeHook(ctx, app)
+			if err != nil {
+				return err
+			}
+		}
+
+		var before reflect.Value
+		if audited && action != AuditInsert {
+			before, err = lockRow(ctx, app, value, m)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = change(ctx, value, m)
+		if err != nil {
+			return err
+		}
+
+		if afterHook != nil {
+			err = afterHook(ctx, app)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !audited {
+			return nil
+		}
+
+		after := value
+		if action == AuditForceDelete {
+			after = reflect.Value{}
+		}
+
+		var rowId string
+		if m.hasKey() {
+			rowId = m.rowId(value)
+		}
+
+		return recordAudit(ctx, app, m, action, rowId, before, after)
+	})
+}
+
+// insertRow inserts the model and reads the stored row back into it. Keys generated in Go are set first, on dialects
+// without RETURNING an auto-incremented key is taken from the result and the row is selected again
+func insertRow(ctx context.Context, app *app.App, value reflect.Value, m *model) error {
+	if m.version >= 0 {
+		value.Field(m.version).SetInt(1)
+	}
+
+	now := time.Now()
+	if m.createdAt >= 0 && value.Field(m.createdAt).IsZero() {
+		value.Field(m.createdAt).Set(reflect.ValueOf(now))
+	}
+	if m.updatedAt >= 0 {
+		value.Field(m.updatedAt).Set(reflect.ValueOf(now))
+	}
+
+	d := DialectOf(app)
+	err := m.generateKey(d, value)
+	if err != nil {
+		return err
+	}
+
+	var columns []string
+	var args []any
+	if !m.databaseGeneratesKey(d) {
+		columns = append(columns, m.keyColumns...)
+		args = append(args, m.keyValues(value)...)
+	}
+	columns = append(columns, m.columns...)
+	args = append(args, m.values(value)...)
+
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = d.QuoteIdentifier(column)
+		placeholders[i] = "?"
+	}
+
+	query := "INSERT INTO " + m.qualifiedTable(ctx, app) + " (" + strings.Join(quoted, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+
+	ctx, cancel := WithQueryTimeout(ctx, app)
+	defer cancel()
+
+	if d.SupportsReturning() {
+		query += " RETURNING " + m.selectColumns(d)
+		return Writer(ctx, app).QueryRowContext(ctx, rebind(d, query), args...).Scan(m.scanTargets(value)...)
+	}
+
+	result, err := Writer(ctx, app).ExecContext(ctx, rebind(d, query), args...)
+	if err != nil || !m.hasKey() {
+		return err
+	}
+
+	if m.autoIncrements() {
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		value.Field(m.keys[0]).SetInt(id)
+	}
+
+	return selectRow(ctx, app, m, m.keyValues(value), value, "")
+}
+
+// updateRow saves the model over the row with the same Id and reads the stored row back into it
+func updateRow(ctx context.Context, app *app.App, value reflect.Value, m *model) error {
+	if !m.hasKey() {
+		return errors.New("update requires " + m.table + " to have a primary key")
+	}
+
+	if m.updatedAt >= 0 {
+		value.Field(m.updatedAt).Set(reflect.ValueOf(time.Now()))
+	}
+
+	d := DialectOf(app)
+	var sets []string
+	var args []any
+	for i, column := range m.columns {
+		if m.fields[i] == m.createdAt {
+			continue
+		}
+
+		if m.fields[i] == m.version {
+			sets = append(sets, "\"Version\" = \"Version\" + 1")
+			continue
+		}
+
+		args = append(args, value.Field(m.fields[i]).Interface())
+		sets = append(sets, d.QuoteIdentifier(column)+" = ?")
+	}
+	args = append(args, m.keyValues(value)...)
+	where := m.keyCondition(d)
+
+	if m.version >= 0 {
+		args = append(args, value.Field(m.version).Int())
+		where += " AND \"Version\" = ?"
+	}
+
+	query := "UPDATE " + m.qualifiedTable(ctx, app) + " SET " + strings.Join(sets, ", ") + " WHERE " + where
+
+	ctx, cancel := WithQueryTimeout(ctx, app)
+	defer cancel()
+
+	var err error
+	if d.SupportsReturning() {
+		query += " RETURNING " + m.selectColumns(d)
+		err = Writer(ctx, app).QueryRowContext(ctx, rebind(d, query), args...).Scan(m.scanTargets(value)...)
+	} else {
+		var result sql.Result
+		result, err = Writer(ctx, app).ExecContext(ctx, rebind(d, query), args...)
+		if err == nil {
+			err = expectAffected(result)
+		}
+		if err == nil {
+			err = selectRow(ctx, app, m, m.keyValues(value), value, "")
+		}
+	}
+
+	if errors.Is(err, sql.ErrNoRows) && m.version >= 0 {
+		// Tell a missing row apart from a stale version, the row may only have been changed concurrently if it exists
+		exists, existsErr := rowExists(ctx, app, m, m.keyValues(value))
+		if existsErr != nil {
+			return existsErr
+		}
+
+		if exists {
+			return &ConflictError{Table: m.table, Id: m.keyOf(value), Version: value.Field(m.version).Int()}
+		}
+	}
+
+	return err
+}
+
+// rowExists reports whether a row with the given primary key exists, including soft deleted rows
+func rowExists(ctx context.Context, app *app.App, m *model, key []any) (bool, error) {
+	d := DialectOf(app)
+	query := "SELECT EXISTS (SELECT 1 FROM " + m.qualifiedTable(ctx, app) + " WHERE " + m.keyCondition(d) + ")"
+	return existsQuery(ctx, Writer(ctx, app), rebind(d, query), key...)
+}
+
+// lockRow reads the stored row of the model, including soft deleted rows, and locks it until the transaction ends
+func lockRow(ctx context.Context, app *app.App, value reflect.Value, m *model) (reflect.Value, error) {
+	if !m.hasKey() {
+		return reflect.Value{}, errors.New(m.table + " must have a primary key to be changed")
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx, app)
+	defer cancel()
+
+	stored := reflect.New(value.Type()).Elem()
+	err := selectRow(ctx, app, m, m.keyValues(value), stored, DialectOf(app).LockForUpdate())
+	return stored, err
+}
+
+// selectRow reads the row with the given primary key from the primary into value, including soft deleted rows.
+// suffix is appended to the query, e.g. to lock the row
+func selectRow(ctx context.Context, app *app.App, m *model, key []any, value reflect.Value, suffix string) error {
+	d := DialectOf(app)
+	query := "SELECT " + m.selectColumns(d) + " FROM " + m.qualifiedTable(ctx, app) + " WHERE " + m.keyCondition(d) + suffix
+	return Writer(ctx, app).QueryRowContext(ctx, rebind(d, query), key...).Scan(m.scanTargets(value)...)
+}
+
+// setDeletedAt updates the DeletedAt column of the row of the model when condition holds
+func setDeletedAt(ctx context.Context, app *app.App, value reflect.Value, m *model, deletedAt sql.NullTime, condition string) error {
+	if !m.hasKey() {
+		return errors.New("delete requires " + m.table + " to have a primary key")
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx, app)
+	defer cancel()
+
+	d := DialectOf(app)
+	query := "UPDATE " + m.qualifiedTable(ctx, app) + " SET \"DeletedAt\" = ? WHERE " + m.keyCondition(d) + " AND " + condition
+	args := append([]any{deletedAt}, m.keyValues(value)...)
+	result, err := Writer(ctx, app).ExecContext(ctx, rebind(d, query), args...)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result)
+}
+
+// expectAffected returns sql.ErrNoRows if the statement didn't affect any row
+func expectAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
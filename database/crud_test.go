@@ -0,0 +1,204 @@
+package database_test
+
+import (
+	"GoWeb/database"
+	"GoWeb/testsupport"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Memo is a soft deleted test model
+type Memo struct {
+	Id        int64
+	Title     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt sql.NullTime
+}
+
+func migrateMemo(t *testing.T) {
+	migrate(t, Memo{
+		Id:        1,
+		Title:     "migrate",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		DeletedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+}
+
+func TestDeleteHidesRowUntilRestored(t *testing.T) {
+	migrateMemo(t)
+	ctx, app := testsupport.Tx(t)
+
+	kept := Memo{Title: "kept"}
+	deleted := Memo{Title: "deleted"}
+	for _, memo := range []*Memo{&kept, &deleted} {
+		err := database.Insert(ctx, app, memo)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := database.Delete(ctx, app, &deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted.DeletedAt.Valid {
+		t.Fatal("DeletedAt of the deleted model was not set")
+	}
+
+	_, err = database.Find[Memo](ctx, app, deleted.Id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("find deleted: got %v, want sql.ErrNoRows", err)
+	}
+
+	visible, err := database.From[Memo](app).All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visible) != 1 || visible[0].Id != kept.Id {
+		t.Fatalf("got %v, want only the kept memo", visible)
+	}
+
+	all, err := database.From[Memo](app).WithTrashed().Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all != 2 {
+		t.Fatalf("WithTrashed counted %d memos, want 2", all)
+	}
+
+	trashed, err := database.From[Memo](app).OnlyTrashed().All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) != 1 || trashed[0].Id != deleted.Id {
+		t.Fatalf("OnlyTrashed got %v, want only the deleted memo", trashed)
+	}
+
+	err = database.Delete(ctx, app, &deleted)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("delete twice: got %v, want sql.ErrNoRows", err)
+	}
+
+	err = database.Restore(ctx, app, &deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.DeletedAt.Valid {
+		t.Fatal("DeletedAt of the restored model is still set")
+	}
+
+	_, err = database.Find[Memo](ctx, app, deleted.Id)
+	if err != nil {
+		t.Fatalf("find restored: %v", err)
+	}
+
+	err = database.Restore(ctx, app, &deleted)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("restore twice: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestQueryDeleteSoftDeletesAndForceDeleteRemoves(t *testing.T) {
+	migrateMemo(t)
+	ctx, app := testsupport.Tx(t)
+
+	for _, title := range []string{"old", "old", "new"} {
+		err := database.Insert(ctx, app, &Memo{Title: title})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := database.From[Memo](app).Where("\"Title\" = ?", "old").Delete(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("deleted %d memos, want 2", deleted)
+	}
+
+	trashed, err := database.From[Memo](app).OnlyTrashed().Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trashed != 2 {
+		t.Fatalf("got %d trashed memos, want 2", trashed)
+	}
+
+	removed, err := database.From[Memo](app).WithTrashed().ForceDelete(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 3 {
+		t.Fatalf("force deleted %d memos, want 3", removed)
+	}
+
+	left, err := database.From[Memo](app).WithTrashed().Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Fatalf("%d memos are left after force deleting, want none", left)
+	}
+}
+
+// Ticket is a test model with optimistic locking
+type Ticket struct {
+	Id        int64
+	Title     string
+	Version   int64
+	UpdatedAt time.Time
+}
+
+func TestUpdateOfStaleVersionConflicts(t *testing.T) {
+	migrate(t, Ticket{Id: 1, Title: "migrate", Version: 1, UpdatedAt: time.Now()})
+	ctx, app := testsupport.Tx(t)
+
+	ticket := Ticket{Title: "first"}
+	err := database.Insert(ctx, app, &ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.Version != 1 {
+		t.Fatalf("inserted version %d, want 1", ticket.Version)
+	}
+
+	stale := ticket
+	ticket.Title = "second"
+	err = database.Update(ctx, app, &ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.Version != 2 {
+		t.Fatalf("updated version %d, want 2", ticket.Version)
+	}
+
+	stale.Title = "lost"
+	err = database.Update(ctx, app, &stale)
+	if !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("got %v, want ErrConflict", err)
+	}
+
+	var conflict *database.ConflictError
+	if !errors.As(err, &conflict) || conflict.Table != "Ticket" || conflict.Version != 1 {
+		t.Fatalf("got %#v, want a ConflictError of Ticket expecting version 1", err)
+	}
+
+	stored, err := database.Find[Ticket](ctx, app, ticket.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "second" || stored.Version != 2 {
+		t.Fatalf("stored %q at version %d, want the second title at version 2", stored.Title, stored.Version)
+	}
+
+	missing := Ticket{Id: ticket.Id + 1, Title: "missing", Version: 1}
+	err = database.Update(ctx, app, &missing)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("update of a missing row: got %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,476 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Dialect hides the differences between the SQL databases the data layer can run on. Postgres is the default and the
+// only one supporting schemas, multi-tenant mode, full-text search and pub-sub. The SQLite and MySQL drivers aren't
+// bundled, blank import one (e.g. modernc.org/sqlite or github.com/go-sql-driver/mysql) in main.go to use them
+type Dialect interface {
+	// Name is the value of DbDialect selecting the dialect
+	Name() string
+	// DriverName is the database/sql driver used unless DbDriverName overrides it
+	DriverName() string
+	// ConnectionString builds the driver connection string from the configuration
+	ConnectionString(app *app.App) string
+	// Placeholder returns the bind parameter for the nth argument of a statement, starting at 1
+	Placeholder(n int) string
+	// QuoteIdentifier quotes a table or column name
+	QuoteIdentifier(name string) string
+	// ColumnType returns the column type storing values of the named Go type
+	ColumnType(goType string) (string, error)
+	// KeyColumnType returns the column type storing primary key values of the named Go type
+	KeyColumnType(goType string) (string, error)
+	// AutoIncrement returns the definition of an auto-incrementing integer primary key column, big selects 64 bits
+	AutoIncrement(column string, big bool) string
+	// UUIDType returns the column type storing UUIDs in their text form
+	UUIDType() string
+	// UUIDDefault returns the expression generating a random UUID as column default, empty if there is none
+	UUIDDefault() string
+	// TableExists reports whether the table exists in the schema, schema is ignored without schema support
+	TableExists(ctx context.Context, db Executor, schema string, table string) (bool, error)
+	// ColumnExists reports whether the column exists in the table
+	ColumnExists(ctx context.Context, db Executor, schema string, table string, column string) (bool, error)
+	// IndexExists reports whether an index with the name exists on the table
+	IndexExists(ctx context.Context, db Executor, schema string, table string, index string) (bool, error)
+	// IndexExpression returns the key of an index over the column, lower indexes the lowercased text
+	IndexExpression(column string, lower bool) string
+	// SupportsReturning reports whether INSERT and UPDATE accept a RETURNING clause
+	SupportsReturning() bool
+	// SupportsSchemas reports whether tables can be qualified with a schema
+	SupportsSchemas() bool
+	// LockForUpdate returns the clause appended to a SELECT to lock the rows read until the transaction ends
+	LockForUpdate() string
+	// Upsert returns the clause appended to an INSERT to update the existing row when the conflict columns clash,
+	// update columns take the inserted value and increment columns are increased by one
+	Upsert(table string, conflict []string, update []string, increment []string) string
+	// ResetSequence returns a statement moving the sequence of the auto-incrementing column past its largest value,
+	// empty when the database does this by itself
+	ResetSequence(table string, column string) string
+}
+
+type postgresDialect struct{}
+type sqliteDialect struct{}
+type mysqlDialect struct{}
+
+var dialects = map[string]Dialect{
+	"postgres": postgresDialect{},
+	"sqlite":   sqliteDialect{},
+	"mysql":    mysqlDialect{},
+}
+
+// DialectOf returns the dialect selected by the configuration, Postgres when DbDialect is empty
+func DialectOf(app *app.App) Dialect {
+	if app.Config.Db.Dialect == "" {
+		return postgresDialect{}
+	}
+
+	d, ok := dialects[app.Config.Db.Dialect]
+	if !ok {
+		panic("unknown DbDialect: " + app.Config.Db.Dialect)
+	}
+
+	return d
+}
+
+// ErrUnsupported is matched by the errors of features the configured dialect can't provide
+var ErrUnsupported = errors.New("not supported by the database dialect")
+
+// unsupported returns an error matching ErrUnsupported naming the feature and the dialect
+func unsupported(app *app.App, feature string) error {
+	return fmt.Errorf("%s is %w %s", feature, ErrUnsupported, DialectOf(app).Name())
+}
+
+// driverName returns the database/sql driver name to open connections with
+func driverName(app *app.App) string {
+	if app.Config.Db.DriverName != "" {
+		return app.Config.Db.DriverName
+	}
+
+	return DialectOf(app).DriverName()
+}
+
+// isPostgres reports whether the app runs on Postgres, features built on Postgres extensions check it
+func isPostgres(app *app.App) bool {
+	return DialectOf(app).Name() == "postgres"
+}
+
+// rebind replaces each ? placeholder in query with the placeholder of the dialect. Question marks inside quoted
+// strings and identifiers are left alone, ?? outside them stands for a literal ? such as the Postgres jsonb operator
+func rebind(d Dialect, query string) string {
+	if d.Placeholder(1) == "?" && !strings.Contains(query, "??") {
+		return query
+	}
+
+	var builder strings.Builder
+	var quote byte
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0: // Doubled quotes escaping a quote close and reopen the quoted section, which works out
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?' && i+1 < len(query) && query[i+1] == '?':
+			builder.WriteByte('?')
+			i++
+			continue
+		case c == '?':
+			n++
+			builder.WriteString(d.Placeholder(n))
+			continue
+		}
+		builder.WriteByte(c)
+	}
+
+	return builder.String()
+}
+
+// existsQuery runs a SELECT EXISTS query and returns its result
+func existsQuery(ctx context.Context, db Executor, query string, args ...any) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, query, args...).Scan(&exists)
+	return exists, err
+}
+
+func (postgresDialect) Name() string {
+	return "postgres"
+}
+
+func (postgresDialect) DriverName() string {
+	return "postgres"
+}
+
+func (postgresDialect) ConnectionString(app *app.App) string {
+	return fmt.Sprintf("host=%s port=%s user=%s "+
+		"password=%s dbname=%s sslmode=disable application_name=%s",
+		app.Config.Db.Ip, app.Config.Db.Port, app.Config.Db.User, app.Config.Db.Password, app.Config.Db.Name, InstanceName())
+}
+
+func (postgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (postgresDialect) QuoteIdentifier(name string) string {
+	return pq.QuoteIdentifier(name)
+}
+
+func (postgresDialect) ColumnType(goType string) (string, error) {
+	return getPostgresType(goType)
+}
+
+func (postgresDialect) KeyColumnType(goType string) (string, error) {
+	return getPostgresType(goType)
+}
+
+func (d postgresDialect) AutoIncrement(column string, big bool) string {
+	if big {
+		return d.QuoteIdentifier(column) + " bigserial primary key"
+	}
+
+	return d.QuoteIdentifier(column) + " serial primary key"
+}
+
+func (postgresDialect) UUIDType() string {
+	return "uuid"
+}
+
+func (postgresDialect) UUIDDefault() string {
+	return "gen_random_uuid()"
+}
+
+func (postgresDialect) TableExists(ctx context.Context, db Executor, schema string, table string) (bool, error) {
+	return existsQuery(ctx, db, "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace WHERE c.relname = $1 AND n.nspname = $2)", table, schema)
+}
+
+func (postgresDialect) ColumnExists(ctx context.Context, db Executor, schema string, table string, column string) (bool, error) {
+	return existsQuery(ctx, db, "SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2 AND column_name = $3)", schema, table, column)
+}
+
+func (postgresDialect) IndexExists(ctx context.Context, db Executor, schema string, table string, index string) (bool, error) {
+	return existsQuery(ctx, db, "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_indexes WHERE schemaname = $1 AND tablename = $2 AND indexname = $3)", schema, table, index)
+}
+
+func (d postgresDialect) IndexExpression(column string, lower bool) string {
+	return lowerIndexExpression(d, column, lower)
+}
+
+func (postgresDialect) SupportsReturning() bool {
+	return true
+}
+
+func (postgresDialect) SupportsSchemas() bool {
+	return true
+}
+
+func (postgresDialect) LockForUpdate() string {
+	return " FOR UPDATE"
+}
+
+func (d postgresDialect) Upsert(table string, conflict []string, update []string, increment []string) string {
+	return upsertOnConflict(d, table+".", conflict, update, increment)
+}
+
+func (d postgresDialect) ResetSequence(table string, column string) string {
+	return "SELECT setval(pg_get_serial_sequence(" + pq.QuoteLiteral(table) + ", " + pq.QuoteLiteral(column) + "), COALESCE(MAX(" + d.QuoteIdentifier(column) + "), 0) + 1, false) FROM " + table
+}
+
+func (sqliteDialect) Name() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) DriverName() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) ConnectionString(app *app.App) string {
+	return app.Config.Db.Name // Path of the database file
+}
+
+func (sqliteDialect) Placeholder(_ int) string {
+	return "?"
+}
+
+func (sqliteDialect) QuoteIdentifier(name string) string {
+	return pq.QuoteIdentifier(name) // SQLite accepts standard double quoted identifiers
+}
+
+func (sqliteDialect) ColumnType(goType string) (string, error) {
+	switch goType {
+	case "int", "int32", "uint", "uint32", "int64", "uint64", "int16", "int8", "uint16", "uint8", "byte", "bool", "NullInt64", "NullBool":
+		return "INTEGER", nil
+	case "string", "NullString":
+		return "TEXT", nil
+	case "float64":
+		return "REAL", nil
+	case "Time", "NullTime":
+		return "DATETIME", nil
+	case "[]byte", "EncryptedString":
+		return "BLOB", nil
+	}
+
+	return "", fmt.Errorf("Unknown type: %s", goType)
+}
+
+func (d sqliteDialect) KeyColumnType(goType string) (string, error) {
+	return d.ColumnType(goType)
+}
+
+func (d sqliteDialect) AutoIncrement(column string, _ bool) string {
+	return d.QuoteIdentifier(column) + " INTEGER PRIMARY KEY AUTOINCREMENT" // INTEGER is always 64 bits
+}
+
+func (sqliteDialect) UUIDType() string {
+	return "TEXT"
+}
+
+func (sqliteDialect) UUIDDefault() string {
+	return ""
+}
+
+func (sqliteDialect) TableExists(ctx context.Context, db Executor, _ string, table string) (bool, error) {
+	return existsQuery(ctx, db, "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", table)
+}
+
+func (sqliteDialect) ColumnExists(ctx context.Context, db Executor, _ string, table string, column string) (bool, error) {
+	return existsQuery(ctx, db, "SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, column)
+}
+
+func (sqliteDialect) IndexExists(ctx context.Context, db Executor, _ string, table string, index string) (bool, error) {
+	return existsQuery(ctx, db, "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND name = ?)", table, index)
+}
+
+func (d sqliteDialect) IndexExpression(column string, lower bool) string {
+	return lowerIndexExpression(d, column, lower)
+}
+
+func (sqliteDialect) SupportsReturning() bool {
+	return true // Since SQLite 3.35
+}
+
+func (sqliteDialect) SupportsSchemas() bool {
+	return false
+}
+
+func (sqliteDialect) LockForUpdate() string {
+	return "" // Writes lock the whole database
+}
+
+func (d sqliteDialect) Upsert(_ string, conflict []string, update []string, increment []string) string {
+	return upsertOnConflict(d, "", conflict, update, increment)
+}
+
+func (sqliteDialect) ResetSequence(_ string, _ string) string {
+	return "" // AUTOINCREMENT continues after the largest Id
+}
+
+func (mysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (mysqlDialect) DriverName() string {
+	return "mysql"
+}
+
+func (mysqlDialect) ConnectionString(app *app.App) string {
+	params := url.Values{}
+	params.Set("parseTime", "true")       // Scan DATETIME columns into time.Time
+	params.Set("clientFoundRows", "true") // Count matched rather than changed rows so updates report missing rows
+	params.Set("sql_mode", "'ANSI_QUOTES,STRICT_ALL_TABLES'")
+
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?%s",
+		app.Config.Db.User, app.Config.Db.Password, app.Config.Db.Ip, app.Config.Db.Port, app.Config.Db.Name, params.Encode())
+}
+
+func (mysqlDialect) Placeholder(_ int) string {
+	return "?"
+}
+
+func (mysqlDialect) QuoteIdentifier(name string) string {
+	return pq.QuoteIdentifier(name) // Connections enable ANSI_QUOTES so model conditions are portable
+}
+
+func (mysqlDialect) ColumnType(goType string) (string, error) {
+	switch goType {
+	case "int", "int32", "uint", "uint32":
+		return "INT", nil
+	case "int64", "uint64", "NullInt64":
+		return "BIGINT", nil
+	case "int16", "int8", "uint16", "uint8", "byte":
+		return "SMALLINT", nil
+	case "string", "NullString":
+		return "TEXT", nil
+	case "float64":
+		return "DOUBLE", nil
+	case "bool", "NullBool":
+		return "BOOLEAN", nil
+	case "Time", "NullTime":
+		return "DATETIME(6)", nil
+	case "[]byte", "EncryptedString":
+		return "LONGBLOB", nil
+	}
+
+	return "", fmt.Errorf("Unknown type: %s", goType)
+}
+
+func (d mysqlDialect) KeyColumnType(goType string) (string, error) {
+	if goType == "string" {
+		return "VARCHAR(255)", nil // TEXT columns can't be indexed without a prefix length
+	}
+
+	return d.ColumnType(goType)
+}
+
+func (d mysqlDialect) AutoIncrement(column string, big bool) string {
+	if big {
+		return d.QuoteIdentifier(column) + " BIGINT AUTO_INCREMENT PRIMARY KEY"
+	}
+
+	return d.QuoteIdentifier(column) + " INT AUTO_INCREMENT PRIMARY KEY"
+}
+
+func (mysqlDialect) UUIDType() string {
+	return "CHAR(36)"
+}
+
+func (mysqlDialect) UUIDDefault() string {
+	return "" // Expression defaults need MySQL 8.0.13, UUIDs are generated in Go instead
+}
+
+func (mysqlDialect) TableExists(ctx context.Context, db Executor, _ string, table string) (bool, error) {
+	return existsQuery(ctx, db, "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?)", table)
+}
+
+func (mysqlDialect) ColumnExists(ctx context.Context, db Executor, _ string, table string, column string) (bool, error) {
+	return existsQuery(ctx, db, "SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?)", table, column)
+}
+
+func (mysqlDialect) IndexExists(ctx context.Context, db Executor, _ string, table string, index string) (bool, error) {
+	return existsQuery(ctx, db, "SELECT EXISTS (SELECT 1 FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?)", table, index)
+}
+
+func (d mysqlDialect) IndexExpression(column string, lower bool) string {
+	// TEXT columns need a prefix length and expressions a fixed length type, both need MySQL 8.0.13
+	if lower {
+		return "(CAST(lower(" + d.QuoteIdentifier(column) + ") AS CHAR(255)))"
+	}
+
+	return d.QuoteIdentifier(column) + "(255)"
+}
+
+func (mysqlDialect) SupportsReturning() bool {
+	return false
+}
+
+func (mysqlDialect) SupportsSchemas() bool {
+	return false
+}
+
+func (mysqlDialect) LockForUpdate() string {
+	return " FOR UPDATE"
+}
+
+func (d mysqlDialect) Upsert(_ string, conflict []string, update []string, increment []string) string {
+	var assignments []string
+	for _, column := range update {
+		assignments = append(assignments, d.QuoteIdentifier(column)+" = VALUES("+d.QuoteIdentifier(column)+")")
+	}
+	for _, column := range increment {
+		assignments = append(assignments, d.QuoteIdentifier(column)+" = "+d.QuoteIdentifier(column)+" + 1")
+	}
+
+	if len(assignments) == 0 {
+		return " ON DUPLICATE KEY UPDATE " + d.QuoteIdentifier(conflict[0]) + " = " + d.QuoteIdentifier(conflict[0])
+	}
+
+	return " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+}
+
+func (mysqlDialect) ResetSequence(_ string, _ string) string {
+	return "" // AUTO_INCREMENT continues after the largest Id
+}
+
+// lowerIndexExpression returns the standard index key shared by Postgres and SQLite, the column or its lowercased text
+func lowerIndexExpression(d Dialect, column string, lower bool) string {
+	if lower {
+		return "lower(" + d.QuoteIdentifier(column) + ")"
+	}
+
+	return d.QuoteIdentifier(column)
+}
+
+// upsertOnConflict builds the standard ON CONFLICT clause shared by Postgres and SQLite, existing is the prefix that
+// refers to the stored row in increments
+func upsertOnConflict(d Dialect, existing string, conflict []string, update []string, increment []string) string {
+	quoted := make([]string, len(conflict))
+	for i, column := range conflict {
+		quoted[i] = d.QuoteIdentifier(column)
+	}
+
+	var assignments []string
+	for _, column := range update {
+		assignments = append(assignments, d.QuoteIdentifier(column)+" = excluded."+d.QuoteIdentifier(column))
+	}
+	for _, column := range increment {
+		assignments = append(assignments, d.QuoteIdentifier(column)+" = "+existing+d.QuoteIdentifier(column)+" + 1")
+	}
+
+	clause := " ON CONFLICT (" + strings.Join(quoted, ", ") + ") DO "
+	if len(assignments) == 0 {
+		return clause + "NOTHING"
+	}
+
+	return clause + "UPDATE SET " + strings.Join(assignments, ", ")
+}
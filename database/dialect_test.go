@@ -0,0 +1,26 @@
+package database
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query    string
+		postgres string
+		mysql    string
+	}{
+		{`"Id" = ? AND "Name" = ?`, `"Id" = $1 AND "Name" = $2`, `"Id" = ? AND "Name" = ?`},
+		{`"Note" = 'why?' AND "Id" = ?`, `"Note" = 'why?' AND "Id" = $1`, `"Note" = 'why?' AND "Id" = ?`},
+		{`"Note" = 'it''s ?' AND "Id" = ?`, `"Note" = 'it''s ?' AND "Id" = $1`, `"Note" = 'it''s ?' AND "Id" = ?`},
+		{`"Odd?" = ?`, `"Odd?" = $1`, `"Odd?" = ?`},
+		{`"Data" ?? ? AND "Id" = ?`, `"Data" ? $1 AND "Id" = $2`, `"Data" ? ? AND "Id" = ?`},
+	}
+
+	for _, test := range tests {
+		if got := rebind(postgresDialect{}, test.query); got != test.postgres {
+			t.Errorf("postgres: rebind(%s) = %s, want %s", test.query, got, test.postgres)
+		}
+		if got := rebind(mysqlDialect{}, test.query); got != test.mysql {
+			t.Errorf("mysql: rebind(%s) = %s, want %s", test.query, got, test.mysql)
+		}
+	}
+}
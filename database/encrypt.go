@@ -0,0 +1,337 @@
+package database
+
+import (
+	"GoWeb/app"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// EncryptedString is a string stored AES-256-GCM encrypted, it is encrypted when written and decrypted when scanned so
+// models use it like a plain string. Stored values start with the ID of the key they were encrypted with so keys can
+// be rotated: add a new key, make it current and run ReEncrypt before removing the old one. The empty string is
+// stored as NULL. Encrypted fields are always redacted from the audit trail
+type EncryptedString string
+
+// keyring holds the ciphers of every configured key
+type keyring struct {
+	current string
+	ciphers map[string]cipher.AEAD
+}
+
+var encryptionKeys atomic.Pointer[keyring]
+
+var encryptedStringType = reflect.TypeOf(EncryptedString(""))
+
+// reEncryptBatch is how many rows ReEncrypt reads at a time
+const reEncryptBatch = 500
+
+// ErrNoEncryptionKeys is returned when an encrypted value is read or written without encryption keys configured
+var ErrNoEncryptionKeys = errors.New("encryption keys aren't configured")
+
+// LoadEncryptionKeys prepares the configured encryption keys, it must be called before encrypted fields are used
+func LoadEncryptionKeys(app *app.App) error {
+	if len(app.Config.Encryption.Keys) == 0 {
+		encryptionKeys.Store(nil)
+		return nil
+	}
+
+	ring := &keyring{current: app.Config.Encryption.CurrentKey, ciphers: make(map[string]cipher.AEAD)}
+	for id, encoded := range app.Config.Encryption.Keys {
+		if id == "" || strings.Contains(id, ":") {
+			return errors.New("encryption key ID must be non-empty and can't contain a colon: " + id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return errors.New("encryption key " + id + " must be 32 bytes encoded as base64")
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+
+		ring.ciphers[id], err = cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, ok := ring.ciphers[ring.current]; !ok {
+		return errors.New("current encryption key " + ring.current + " isn't one of the configured keys")
+	}
+
+	encryptionKeys.Store(ring)
+	return nil
+}
+
+// EncryptionConfigured reports whether encryption keys are loaded, encrypted fields can't be written or read without
+func EncryptionConfigured() bool {
+	return encryptionKeys.Load() != nil
+}
+
+// Value encrypts the string with the current key
+func (s EncryptedString) Value() (driver.Value, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	ring := encryptionKeys.Load()
+	if ring == nil {
+		return nil, ErrNoEncryptionKeys
+	}
+
+	return ring.encrypt([]byte(s))
+}
+
+// Scan decrypts a stored value with the key it was encrypted with
+func (s *EncryptedString) Scan(src any) error {
+	if src == nil {
+		*s = ""
+		return nil
+	}
+
+	var stored []byte
+	switch src := src.(type) {
+	case []byte:
+		stored = src
+	case string:
+		stored = []byte(src)
+	default:
+		return fmt.Errorf("can't scan %T into an EncryptedString", src)
+	}
+
+	ring := encryptionKeys.Load()
+	if ring == nil {
+		return ErrNoEncryptionKeys
+	}
+
+	plain, err := ring.decrypt(stored)
+	if err != nil {
+		return err
+	}
+
+	*s = EncryptedString(plain)
+	return nil
+}
+
+// encrypt seals plain with the current key as <key ID>:<nonce><ciphertext>, the key ID is authenticated too
+func (k *keyring) encrypt(plain []byte) ([]byte, error) {
+	aead := k.ciphers[k.current]
+
+	sealed := make([]byte, 0, len(k.current)+1+aead.NonceSize()+len(plain)+aead.Overhead())
+	sealed = append(sealed, k.current+":"...)
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	sealed = append(sealed, nonce...)
+
+	return aead.Seal(sealed, nonce, plain, []byte(k.current)), nil
+}
+
+// decrypt opens a value sealed by encrypt with whichever configured key it names
+func (k *keyring) decrypt(sealed []byte) ([]byte, error) {
+	id, rest, ok := bytes.Cut(sealed, []byte(":"))
+	if !ok {
+		return nil, errors.New("encrypted value has no key ID")
+	}
+
+	aead, ok := k.ciphers[string(id)]
+	if !ok {
+		return nil, errors.New("encrypted value uses unknown key " + string(id))
+	}
+
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+
+	return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], id)
+}
+
+// isCurrent reports whether a stored value was encrypted with the current key
+func (k *keyring) isCurrent(sealed []byte) bool {
+	return bytes.HasPrefix(sealed, []byte(k.current+":"))
+}
+
+// ReEncrypt encrypts every encrypted field of the table of the given dummy model that isn't encrypted with the current
+// key again with it, returning how many rows changed. Rows are read in batches by primary key and updated directly,
+// without hooks, audit entries or version changes since their values stay the same. An update only applies while the
+// row still holds the values that were read, rows written concurrently are skipped so their new values aren't lost
+func ReEncrypt(ctx context.Context, app *app.App, anyStruct any) (int, error) {
+	typeOfStruct := reflect.TypeOf(anyStruct)
+	m, err := modelOf(typeOfStruct)
+	if err != nil {
+		return 0, err
+	}
+
+	var columns []string
+	for i, field := range m.fields {
+		if typeOfStruct.Field(field).Type == encryptedStringType {
+			columns = append(columns, m.columns[i])
+		}
+	}
+
+	if len(columns) == 0 {
+		return 0, nil
+	}
+
+	if len(m.keys) != 1 {
+		return 0, errors.New("re-encrypting " + m.table + " requires a single column primary key")
+	}
+
+	ring := encryptionKeys.Load()
+	if ring == nil {
+		return 0, ErrNoEncryptionKeys
+	}
+
+	d := DialectOf(app)
+	ctx = WithPrimary(ctx)
+	table := m.qualifiedTable(ctx, app)
+	key := d.QuoteIdentifier(m.keyColumns[0])
+
+	quoted := make([]string, len(columns))
+	sets := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = d.QuoteIdentifier(column)
+		sets[i] = quoted[i] + " = ?"
+	}
+
+	changed := 0
+	var last any
+	for {
+		query := "SELECT " + key + ", " + strings.Join(quoted, ", ") + " FROM " + table
+		var args []any
+		if last != nil {
+			query += " WHERE " + key + " > ?"
+			args = append(args, last)
+		}
+		query += " ORDER BY " + key + " LIMIT " + strconv.Itoa(reEncryptBatch)
+
+		batch, err := readEncryptedBatch(ctx, app, rebind(d, query), args, typeOfStruct.Field(m.keys[0]).Type, len(columns))
+		if err != nil {
+			return changed, err
+		}
+
+		for _, row := range batch {
+			previous := slices.Clone(row.values)
+			stale := false
+			for i, value := range row.values {
+				if value == nil || ring.isCurrent(value) {
+					continue
+				}
+
+				plain, err := ring.decrypt(value)
+				if err != nil {
+					return changed, fmt.Errorf("error decrypting %s of %s %v: %w", columns[i], m.table, row.key, err)
+				}
+
+				row.values[i], err = ring.encrypt(plain)
+				if err != nil {
+					return changed, err
+				}
+				stale = true
+			}
+
+			if !stale {
+				continue
+			}
+
+			// Only overwrite the values that were read, a row written in the meantime keeps its new value and is
+			// already encrypted with the current key
+			args := make([]any, 0, 2*len(row.values)+1)
+			for _, value := range row.values {
+				args = append(args, value)
+			}
+			args = append(args, row.key)
+
+			query := "UPDATE " + table + " SET " + strings.Join(sets, ", ") + " WHERE " + key + " = ?"
+			for i, value := range previous {
+				if value == nil {
+					query += " AND " + quoted[i] + " IS NULL"
+					continue
+				}
+
+				query += " AND " + quoted[i] + " = ?"
+				args = append(args, value)
+			}
+
+			result, err := Writer(ctx, app).ExecContext(ctx, rebind(d, query), args...)
+			if err != nil {
+				return changed, err
+			}
+
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return changed, err
+			}
+			if affected == 0 {
+				slog.Debug("skipped re-encrypting row changed concurrently", "table", m.table, "key", row.key)
+				continue
+			}
+			changed++
+		}
+
+		if len(batch) < reEncryptBatch {
+			break
+		}
+		last = batch[len(batch)-1].key
+	}
+
+	slog.Info("re-encrypted rows", "table", m.table, "rows", changed)
+	return changed, nil
+}
+
+// encryptedRow is the primary key and raw encrypted column values of a row read by ReEncrypt
+type encryptedRow struct {
+	key    any
+	values [][]byte
+}
+
+// readEncryptedBatch reads a batch of rows for ReEncrypt, the rows are closed before they are updated
+func readEncryptedBatch(ctx context.Context, app *app.App, query string, args []any, keyType reflect.Type, columns int) ([]encryptedRow, error) {
+	queryCtx, cancel := WithQueryTimeout(ctx, app)
+	defer cancel()
+
+	rows, err := Writer(ctx, app).QueryContext(queryCtx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []encryptedRow
+	for rows.Next() {
+		key := reflect.New(keyType)
+		row := encryptedRow{values: make([][]byte, columns)}
+
+		targets := []any{key.Interface()}
+		for i := range row.values {
+			targets = append(targets, &row.values[i])
+		}
+
+		err = rows.Scan(targets...)
+		if err != nil {
+			return nil, err
+		}
+
+		row.key = key.Elem().Interface()
+		batch = append(batch, row)
+	}
+
+	return batch, rows.Err()
+}
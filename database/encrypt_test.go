@@ -0,0 +1,130 @@
+package database
+
+import (
+	"GoWeb/app"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// useEncryptionKeys loads keys named by their ID with the current one until the test ends, the key bytes are
+// derived from the ID
+func useEncryptionKeys(t *testing.T, current string, ids ...string) {
+	t.Helper()
+	t.Cleanup(func() { encryptionKeys.Store(nil) })
+
+	testApp := &app.App{}
+	testApp.Config.Encryption.CurrentKey = current
+	testApp.Config.Encryption.Keys = make(map[string]string)
+	for _, id := range ids {
+		testApp.Config.Encryption.Keys[id] = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte(id[:1]), 32))
+	}
+
+	err := LoadEncryptionKeys(testApp)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// encryptString returns what is stored for s
+func encryptString(t *testing.T, s EncryptedString) []byte {
+	t.Helper()
+
+	value, err := s.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, ok := value.([]byte)
+	if !ok {
+		t.Fatalf("stored value is %T, want []byte", value)
+	}
+
+	return stored
+}
+
+func TestEncryptedStringRoundTrip(t *testing.T) {
+	useEncryptionKeys(t, "a", "a")
+
+	stored := encryptString(t, "secret")
+	if !bytes.HasPrefix(stored, []byte("a:")) {
+		t.Fatalf("stored value %q doesn't start with the key ID", stored)
+	}
+	if bytes.Contains(stored, []byte("secret")) {
+		t.Fatal("stored value contains the plain text")
+	}
+
+	var scanned EncryptedString
+	err := scanned.Scan(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanned != "secret" {
+		t.Fatalf("got %q, want %q", scanned, "secret")
+	}
+
+	if bytes.Equal(stored, encryptString(t, "secret")) {
+		t.Fatal("encrypting the same value twice gave the same result, nonces aren't random")
+	}
+
+	value, err := EncryptedString("").Value()
+	if err != nil || value != nil {
+		t.Fatalf("empty string stored as %v, %v, want NULL", value, err)
+	}
+}
+
+func TestEncryptedStringRejectsTampering(t *testing.T) {
+	useEncryptionKeys(t, "a", "a")
+
+	stored := encryptString(t, "secret")
+	stored[len(stored)-1] ^= 1
+
+	var scanned EncryptedString
+	if scanned.Scan(stored) == nil {
+		t.Fatal("tampered value was decrypted")
+	}
+}
+
+func TestEncryptedStringKeyRotation(t *testing.T) {
+	useEncryptionKeys(t, "a", "a")
+	old := encryptString(t, "secret")
+
+	// Adding a new current key still decrypts values of the old one, new values use the new key
+	useEncryptionKeys(t, "b", "a", "b")
+	var scanned EncryptedString
+	err := scanned.Scan(old)
+	if err != nil || scanned != "secret" {
+		t.Fatalf("old value decrypted to %q, %v after rotation", scanned, err)
+	}
+
+	ring := encryptionKeys.Load()
+	if ring.isCurrent(old) {
+		t.Fatal("value of the old key counted as current, ReEncrypt would skip it")
+	}
+
+	current := encryptString(t, "secret")
+	if !bytes.HasPrefix(current, []byte("b:")) || !ring.isCurrent(current) {
+		t.Fatalf("new value %q isn't encrypted with the current key", current)
+	}
+
+	// Once the old key is removed only re-encrypted values can be read
+	useEncryptionKeys(t, "b", "b")
+	if scanned.Scan(old) == nil {
+		t.Fatal("value of a removed key was decrypted")
+	}
+
+	err = scanned.Scan(current)
+	if err != nil || scanned != "secret" {
+		t.Fatalf("current value decrypted to %q, %v", scanned, err)
+	}
+}
+
+func TestEncryptedStringWithoutKeys(t *testing.T) {
+	encryptionKeys.Store(nil)
+
+	_, err := EncryptedString("secret").Value()
+	if !errors.Is(err, ErrNoEncryptionKeys) {
+		t.Fatalf("got %v, want ErrNoEncryptionKeys", err)
+	}
+}
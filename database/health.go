@@ -0,0 +1,163 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"database/sql"
+	"reflect"
+	"time"
+)
+
+// healthProbeTimeout bounds each connectivity probe so a hung database can't hang the health check
+const healthProbeTimeout = 2 * time.Second
+
+// HealthReport describes the state of the database connections and schema, Healthy is false when the primary is
+// unreachable or migrations are pending. Unhealthy replicas are reported but don't make the report unhealthy since
+// reads fall back to the primary
+type HealthReport struct {
+	Healthy           bool            `json:"healthy"`
+	Primary           ConnectionProbe `json:"primary"`
+	Replicas          []ReplicaProbe  `json:"replicas,omitempty"`
+	PendingMigrations []string        `json:"pendingMigrations,omitempty"` // Missing tables and columns as Table or Table.Column
+	MigrationError    string          `json:"migrationError,omitempty"`
+}
+
+// ConnectionProbe is the result of pinging a connection pool together with its statistics
+type ConnectionProbe struct {
+	Reachable bool        `json:"reachable"`
+	LatencyMs float64     `json:"latencyMs"`
+	Error     string      `json:"error,omitempty"`
+	Stats     sql.DBStats `json:"stats"` // Open, in use and idle connections, waits for a free connection and closes
+}
+
+// ReplicaProbe is the probe of a read replica and how far it lags behind the primary
+type ReplicaProbe struct {
+	ConnectionProbe
+	Index      int      `json:"index"`                // Position of the replica in DbReplicas
+	Routed     bool     `json:"routed"`               // Whether reads are currently routed to the replica
+	LagSeconds *float64 `json:"lagSeconds,omitempty"` // Age of the last replayed transaction, grows while the primary is idle
+}
+
+// Health probes the primary and every replica and checks that the tables and columns of the given dummy models, the
+// same ones passed to Migrate, exist in every schema in use
+func Health(ctx context.Context, app *app.App, dummies ...any) HealthReport {
+	report := HealthReport{Primary: probe(ctx, app.Db)}
+
+	for i, db := range app.Replicas {
+		replica := ReplicaProbe{ConnectionProbe: probe(ctx, db), Index: i, Routed: replicaHealthy(db)}
+		if replica.Reachable && isPostgres(app) {
+			replica.LagSeconds = replicationLag(ctx, db)
+		}
+		report.Replicas = append(report.Replicas, replica)
+	}
+
+	if report.Primary.Reachable {
+		err := EachTenant(ctx, app, func(ctx context.Context) error {
+			pending, err := PendingMigrations(ctx, app, dummies...)
+			if tenant, ok := Tenant(ctx); ok {
+				for i := range pending {
+					pending[i] = tenant + ":" + pending[i]
+				}
+			}
+
+			report.PendingMigrations = append(report.PendingMigrations, pending...)
+			return err
+		})
+		if err != nil {
+			report.MigrationError = err.Error()
+		}
+	}
+
+	report.Healthy = report.Primary.Reachable && len(report.PendingMigrations) == 0 && report.MigrationError == ""
+	return report
+}
+
+// Reachable pings the primary, a cheap check for probes that run far more often than the full Health report
+func Reachable(ctx context.Context, app *app.App) bool {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	return app.Db.PingContext(ctx) == nil
+}
+
+// PendingMigrations returns the tables and columns Migrate would create for the given dummy models in the schema of
+// ctx, as Table or Table.Column
+func PendingMigrations(ctx context.Context, app *app.App, dummies ...any) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	d := DialectOf(app)
+	schema := Schema(ctx, app)
+
+	var pending []string
+	for _, dummy := range dummies {
+		valueOfStruct := reflect.ValueOf(dummy)
+		typeOfStruct := valueOfStruct.Type()
+
+		m, err := modelOf(typeOfStruct)
+		if err != nil {
+			return pending, err
+		}
+
+		exists, err := d.TableExists(ctx, app.Db, schema, m.table)
+		if err != nil {
+			return pending, err
+		}
+		if !exists {
+			pending = append(pending, m.table)
+			continue
+		}
+
+		for i := 0; i < valueOfStruct.NumField(); i++ {
+			if valueOfStruct.Field(i).IsZero() || m.isKey(i) || m.isRelation(i) {
+				continue
+			}
+
+			column := typeOfStruct.Field(i).Name
+			exists, err = d.ColumnExists(ctx, app.Db, schema, m.table, column)
+			if err != nil {
+				return pending, err
+			}
+			if !exists {
+				pending = append(pending, m.table+"."+column)
+			}
+		}
+	}
+
+	return pending, nil
+}
+
+// probe pings the pool and reports how long it took along with the pool statistics
+func probe(ctx context.Context, db *sql.DB) ConnectionProbe {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	started := time.Now()
+	err := db.PingContext(ctx)
+
+	result := ConnectionProbe{
+		Reachable: err == nil,
+		LatencyMs: float64(time.Since(started).Microseconds()) / 1000,
+		Stats:     db.Stats(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// replicationLag returns how many seconds ago the last transaction replayed on a Postgres replica was committed, nil if
+// the server isn't replaying or the lag can't be read
+func replicationLag(ctx context.Context, db *sql.DB) *float64 {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	var lag sql.NullFloat64
+	err := db.QueryRowContext(ctx, "SELECT CASE WHEN pg_is_in_recovery() THEN EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) END").Scan(&lag)
+	if err != nil || !lag.Valid {
+		return nil
+	}
+
+	return &lag.Float64
+}
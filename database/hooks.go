@@ -0,0 +1,78 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+)
+
+// Models implement the hook interfaces below, usually on their pointer type, to validate or react to changes no
+// matter where they are saved from. Hooks run inside the transaction of the change, so their queries see and roll
+// back together with it, and an error returned by a hook aborts the change. Before hooks may still modify the model.
+// Upsert, used by seeders, bypasses hooks
+
+// BeforeCreate is called by Insert before the row is inserted
+type BeforeCreate interface {
+	BeforeCreate(ctx context.Context, app *app.App) error
+}
+
+// AfterCreate is called by Insert once the row, including its generated key, has been read back into the model
+type AfterCreate interface {
+	AfterCreate(ctx context.Context, app *app.App) error
+}
+
+// BeforeUpdate is called by Update before the row is saved
+type BeforeUpdate interface {
+	BeforeUpdate(ctx context.Context, app *app.App) error
+}
+
+// AfterUpdate is called by Update once the saved row has been read back into the model
+type AfterUpdate interface {
+	AfterUpdate(ctx context.Context, app *app.App) error
+}
+
+// BeforeDelete is called by Delete and ForceDelete, including bulk deletes of a query, before the row is removed
+type BeforeDelete interface {
+	BeforeDelete(ctx context.Context, app *app.App) error
+}
+
+// AfterDelete is called by Delete and ForceDelete, including bulk deletes of a query, once the row has been removed
+type AfterDelete interface {
+	AfterDelete(ctx context.Context, app *app.App) error
+}
+
+type hook func(ctx context.Context, app *app.App) error
+
+// hooksOf returns the hooks the model pointed to by ptr implements for the action, nil for the ones it doesn't
+func hooksOf(ptr any, action string) (before hook, after hook) {
+	switch action {
+	case AuditInsert:
+		if h, ok := ptr.(BeforeCreate); ok {
+			before = h.BeforeCreate
+		}
+		if h, ok := ptr.(AfterCreate); ok {
+			after = h.AfterCreate
+		}
+	case AuditUpdate:
+		if h, ok := ptr.(BeforeUpdate); ok {
+			before = h.BeforeUpdate
+		}
+		if h, ok := ptr.(AfterUpdate); ok {
+			after = h.AfterUpdate
+		}
+	case AuditDelete, AuditForceDelete:
+		if h, ok := ptr.(BeforeDelete); ok {
+			before = h.BeforeDelete
+		}
+		if h, ok := ptr.(AfterDelete); ok {
+			after = h.AfterDelete
+		}
+	}
+
+	return before, after
+}
+
+// hasDeleteHooks reports whether deleting the model pointed to by ptr runs hooks, bulk deletes then go row by row
+func hasDeleteHooks(ptr any) bool {
+	before, after := hooksOf(ptr, AuditDelete)
+	return before != nil || after != nil
+}
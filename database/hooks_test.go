@@ -0,0 +1,126 @@
+package database_test
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"GoWeb/testsupport"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Gadget is a test model whose create hooks write a GadgetLog and can be made to fail
+type Gadget struct {
+	Id        int64
+	Name      string
+	CreatedAt time.Time
+
+	failAfter bool // Makes AfterCreate return errHookFailed
+	sawRow    bool // Whether AfterCreate found the inserted row
+}
+
+// GadgetLog is written by the BeforeCreate hook of Gadget
+type GadgetLog struct {
+	Id         int64
+	GadgetName string
+	CreatedAt  time.Time
+}
+
+var errHookFailed = errors.New("hook failed")
+
+func (g *Gadget) BeforeCreate(ctx context.Context, app *app.App) error {
+	return database.Insert(ctx, app, &GadgetLog{GadgetName: g.Name})
+}
+
+func (g *Gadget) AfterCreate(ctx context.Context, app *app.App) error {
+	var err error
+	g.sawRow, err = database.From[Gadget](app).Where("\"Id\" = ?", g.Id).Exists(ctx)
+	if err != nil {
+		return err
+	}
+
+	if g.failAfter {
+		return errHookFailed
+	}
+	return nil
+}
+
+// migrateGadget creates the gadget tables, these tests commit their changes so the rows named name are removed
+// when the test ends
+func migrateGadget(t *testing.T, name string) *app.App {
+	t.Helper()
+
+	migrate(t,
+		Gadget{Id: 1, Name: "migrate", CreatedAt: time.Now()},
+		GadgetLog{Id: 1, GadgetName: "migrate", CreatedAt: time.Now()},
+	)
+	testApp := testsupport.App(t)
+
+	t.Cleanup(func() {
+		_, err := database.From[Gadget](testApp).Where("\"Name\" = ?", name).ForceDelete(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		_, err = database.From[GadgetLog](testApp).Where("\"GadgetName\" = ?", name).ForceDelete(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	return testApp
+}
+
+func TestHooksRunInsideTheTransaction(t *testing.T) {
+	name := t.Name()
+	testApp := migrateGadget(t, name)
+	ctx := context.Background()
+
+	gadget := Gadget{Name: name}
+	err := database.Insert(ctx, testApp, &gadget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gadget.sawRow {
+		t.Fatal("AfterCreate did not see the inserted row")
+	}
+
+	logged, err := database.From[GadgetLog](testApp).Where("\"GadgetName\" = ?", name).Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logged != 1 {
+		t.Fatalf("BeforeCreate logged %d rows, want 1", logged)
+	}
+}
+
+func TestFailingHookRollsBackChangeAndHookWrites(t *testing.T) {
+	name := t.Name()
+	testApp := migrateGadget(t, name)
+	ctx := context.Background()
+
+	gadget := Gadget{Name: name, failAfter: true}
+	err := database.Insert(ctx, testApp, &gadget)
+	if !errors.Is(err, errHookFailed) {
+		t.Fatalf("got %v, want the error of the hook", err)
+	}
+	if !gadget.sawRow {
+		t.Fatal("AfterCreate did not see the inserted row")
+	}
+
+	stored, err := database.From[Gadget](testApp).Where("\"Name\" = ?", name).Exists(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored {
+		t.Fatal("row was kept although AfterCreate failed")
+	}
+
+	logged, err := database.From[GadgetLog](testApp).Where("\"GadgetName\" = ?", name).Exists(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logged {
+		t.Fatal("row written by BeforeCreate was kept although AfterCreate failed")
+	}
+}
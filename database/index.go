@@ -0,0 +1,100 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+)
+
+// index is a secondary index over a single column, declared with index:"" on a field. index:"unique" rejects duplicate
+// values and index:"unique,lower" compares text case-insensitively, e.g. for email addresses. NULLs never clash
+type index struct {
+	column string
+	unique bool
+	lower  bool // Index lower(column) so values differing only in case are the same key
+}
+
+// parseIndex reads the index declared by the index tag of field, ok is false if it doesn't declare one
+func parseIndex(owner reflect.Type, field reflect.StructField) (index, bool, error) {
+	tag, ok := field.Tag.Lookup("index")
+	if !ok {
+		return index{}, false, nil
+	}
+
+	idx := index{column: field.Name}
+	for _, option := range strings.Split(tag, ",") {
+		switch strings.TrimSpace(option) {
+		case "":
+		case "unique":
+			idx.unique = true
+		case "lower":
+			idx.lower = true
+		default:
+			return index{}, false, errors.New("unknown index option of " + field.Name + " of " + owner.Name() + ": " + option)
+		}
+	}
+
+	if idx.lower && field.Type.Name() != "string" && field.Type.Name() != "NullString" {
+		return index{}, false, errors.New("lower index on " + field.Name + " of " + owner.Name() + " requires a string field")
+	}
+
+	return idx, true, nil
+}
+
+// name returns the name of the index, unique within the schema since it starts with the table name
+func (idx index) name(table string) string {
+	if idx.unique {
+		return table + "_" + idx.column + "_key"
+	}
+
+	return table + "_" + idx.column + "_idx"
+}
+
+// indexOn returns the index declared on the column, ok is false if it has none
+func (m *model) indexOn(column string) (index, bool) {
+	for _, idx := range m.indexes {
+		if idx.column == column {
+			return idx, true
+		}
+	}
+
+	return index{}, false
+}
+
+// createIndex creates the index if it doesn't exist yet, an existing index is left as it is so after changing its
+// options drop it to have it rebuilt
+func createIndex(app *app.App, tableName string, idx index) error {
+	ctx := context.Background()
+	d := DialectOf(app)
+	name := idx.name(tableName)
+
+	exists, err := d.IndexExists(ctx, app.Db, Schema(ctx, app), tableName, name)
+	if err != nil {
+		slog.Error("error checking if index exists: " + name)
+		return err
+	}
+
+	if exists {
+		slog.Info("index already exists: " + name)
+		return nil
+	}
+
+	kind := "INDEX"
+	if idx.unique {
+		kind = "UNIQUE INDEX"
+	}
+
+	query := fmt.Sprintf("CREATE %s %s ON %s (%s)", kind, d.QuoteIdentifier(name), tableIn(ctx, app, tableName), d.IndexExpression(idx.column, idx.lower))
+	_, err = app.Db.Exec(query)
+	if err != nil {
+		slog.Error("error creating index: " + name)
+		return err
+	}
+
+	slog.Info("index created successfully: " + name)
+	return nil
+}
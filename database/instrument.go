@@ -0,0 +1,252 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type queryCountContextKey struct{}
+
+// instrumentedConnector wraps the connector of a driver so every statement run through the pool is logged at debug
+// level, reported when slower than the configured threshold and counted against the request it was made for
+type instrumentedConnector struct {
+	connector     driver.Connector
+	slowThreshold time.Duration
+}
+
+// instrumentedConn forwards to the driver connection while timing each statement
+type instrumentedConn struct {
+	driver.Conn
+	slowThreshold time.Duration
+}
+
+// instrumentedStmt forwards to a prepared statement of the driver while timing each execution
+type instrumentedStmt struct {
+	driver.Stmt
+	query         string
+	slowThreshold time.Duration
+}
+
+// dsnConnector adapts a driver that doesn't implement driver.DriverContext into a connector
+type dsnConnector struct {
+	dsn string
+	drv driver.Driver
+}
+
+// openInstrumented opens a connection pool for the named driver with every statement instrumented
+func openInstrumented(app *app.App, driverName string, dsn string) (*sql.DB, error) {
+	// Opening a pool is lazy, it is only used here to look up the registered driver by name
+	lookup, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := lookup.Driver()
+	err = lookup.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var connector driver.Connector = dsnConnector{dsn: dsn, drv: drv}
+	if driverContext, ok := drv.(driver.DriverContext); ok {
+		connector, err = driverContext.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sql.OpenDB(&instrumentedConnector{
+		connector:     connector,
+		slowThreshold: time.Duration(app.Config.Db.SlowQueryThreshold) * time.Millisecond,
+	}), nil
+}
+
+// CountQueries returns a context that counts every statement run with it or a context derived from it
+func CountQueries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryCountContextKey{}, &atomic.Int64{})
+}
+
+// QueryCount returns the number of statements run so far with a context returned by CountQueries
+func QueryCount(ctx context.Context) int64 {
+	if counter, ok := ctx.Value(queryCountContextKey{}).(*atomic.Int64); ok {
+		return counter.Load()
+	}
+
+	return 0
+}
+
+// observeQuery logs a finished statement and counts it against the request carried by ctx
+func observeQuery(ctx context.Context, query string, args []driver.NamedValue, started time.Time, slowThreshold time.Duration, err error) {
+	duration := time.Since(started)
+
+	if counter, ok := ctx.Value(queryCountContextKey{}).(*atomic.Int64); ok {
+		counter.Add(1)
+	}
+
+	if err != nil {
+		slog.Debug("query failed", "query", query, "duration", duration, "error", err)
+	} else {
+		slog.Debug("query", "query", query, "duration", duration)
+	}
+
+	if slowThreshold > 0 && duration >= slowThreshold {
+		slog.Warn("slow query", "query", query, "duration", duration, "args", redactArgs(args))
+	}
+}
+
+// redactArgs describes query arguments by position and type only, so values such as password hashes and tokens never
+// end up in the logs
+func redactArgs(args []driver.NamedValue) string {
+	described := make([]string, len(args))
+	for i, arg := range args {
+		if arg.Value == nil {
+			described[i] = fmt.Sprintf("$%d=NULL", arg.Ordinal)
+		} else {
+			described[i] = fmt.Sprintf("$%d=<%T>", arg.Ordinal, arg.Value)
+		}
+	}
+
+	return strings.Join(described, " ")
+}
+
+func (c *instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &instrumentedConn{Conn: conn, slowThreshold: c.slowThreshold}, nil
+}
+
+func (c *instrumentedConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+func (c dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.drv.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip // database/sql falls back to preparing the statement
+	}
+
+	started := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	observeQuery(ctx, query, args, started, c.slowThreshold, err)
+	return rows, err
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip // database/sql falls back to preparing the statement
+	}
+
+	started := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	observeQuery(ctx, query, args, started, c.slowThreshold, err)
+	return result, err
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &instrumentedStmt{Stmt: stmt, query: query, slowThreshold: c.slowThreshold}, nil
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	return c.Conn.Begin() // Only reached for drivers predating ConnBeginTx
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+
+	return true
+}
+
+func (c *instrumentedConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+
+	return driver.ErrSkip // database/sql falls back to its default conversion
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	started := time.Now()
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(namedValuesToValues(args))
+	}
+	observeQuery(ctx, s.query, args, started, s.slowThreshold, err)
+	return rows, err
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	started := time.Now()
+	var result driver.Result
+	var err error
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		result, err = s.Stmt.Exec(namedValuesToValues(args))
+	}
+	observeQuery(ctx, s.query, args, started, s.slowThreshold, err)
+	return result, err
+}
+
+// namedValuesToValues drops the names and ordinals of arguments for drivers that only accept plain values
+func namedValuesToValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	return values
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// migrationLockKey identifies the advisory lock taken while migrating, every instance of the app uses the same key.
+// It fits in 32 bits so pg_locks reports it in objid
+const migrationLockKey = 0x60_77_eb_01
+
+// migrationLockPoll is how often an instance waiting for the migration lock tries to take it again
+const migrationLockPoll = 500 * time.Millisecond
+
+// defaultMigrationLockTimeout is how long an instance waits for the migration lock when no timeout is configured
+const defaultMigrationLockTimeout = 60 * time.Second
+
+// ErrMigrationLockTimeout is returned when another instance held the migration lock for longer than the timeout
+var ErrMigrationLockTimeout = errors.New("timed out waiting for the migration lock")
+
+// InstanceName identifies this process to the database and in logs, it is reported as the application_name of its
+// Postgres connections so waiting instances can tell who holds the migration lock
+func InstanceName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return "goweb-" + hostname + "-" + strconv.Itoa(os.Getpid())
+}
+
+// WithMigrationLock runs migrate while holding a Postgres advisory lock so instances booting together don't migrate
+// concurrently, the others wait for it and then run migrate themselves, finding nothing left to do. An instance still
+// waiting after DbMigrationLockTimeout seconds gives up without migrating and gets an error matching
+// ErrMigrationLockTimeout. The lock is released when the connection holding it closes, even if the instance crashes.
+// Other dialects run migrate without a lock
+func WithMigrationLock(app *app.App, migrate func() error) error {
+	if !isPostgres(app) {
+		return migrate()
+	}
+
+	timeout := defaultMigrationLockTimeout
+	if app.Config.Db.MigrationLockTimeout > 0 {
+		timeout = time.Duration(app.Config.Db.MigrationLockTimeout) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Advisory locks belong to a session, so the lock is taken and released on one dedicated connection
+	conn, err := app.Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = acquireMigrationLock(ctx, conn)
+	if err != nil {
+		return err
+	}
+	slog.Info("migration lock acquired", "instance", InstanceName())
+
+	migrateErr := migrate()
+
+	// The waiting context may have run out while migrating, releasing must not depend on it
+	_, err = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	if err != nil {
+		slog.Error("error releasing migration lock: " + err.Error())
+	} else {
+		slog.Info("migration lock released", "instance", InstanceName())
+	}
+
+	return migrateErr
+}
+
+// acquireMigrationLock polls until the migration lock is taken on conn or ctx is done, logging the instance holding it
+// whenever it changes
+func acquireMigrationLock(ctx context.Context, conn *sql.Conn) error {
+	var lastHolder string
+	for {
+		var acquired bool
+		err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", migrationLockKey).Scan(&acquired)
+		if err != nil {
+			if ctx.Err() != nil {
+				return migrationLockTimeout(lastHolder)
+			}
+			return err
+		}
+
+		if acquired {
+			return nil
+		}
+
+		holder, err := migrationLockHolder(ctx, conn)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("could not look up the holder of the migration lock", "error", err)
+		}
+		if holder != lastHolder && holder != "" {
+			slog.Info("waiting for migration lock", "heldBy", holder)
+			lastHolder = holder
+		}
+
+		select {
+		case <-ctx.Done():
+			return migrationLockTimeout(lastHolder)
+		case <-time.After(migrationLockPoll):
+		}
+	}
+}
+
+// migrationLockTimeout returns an error matching ErrMigrationLockTimeout naming the instance holding the lock
+func migrationLockTimeout(holder string) error {
+	if holder == "" {
+		holder = "another instance"
+	}
+
+	return fmt.Errorf("%w held by %s", ErrMigrationLockTimeout, holder)
+}
+
+// migrationLockHolder describes the connection holding the migration lock by its application name, address and
+// backend pid, empty if it was released in the meantime
+func migrationLockHolder(ctx context.Context, conn *sql.Conn) (string, error) {
+	var name, address sql.NullString
+	var pid int
+	err := conn.QueryRowContext(ctx, "SELECT a.application_name, host(a.client_addr), a.pid FROM pg_catalog.pg_locks l JOIN pg_catalog.pg_stat_activity a ON a.pid = l.pid WHERE l.locktype = 'advisory' AND l.granted AND l.classid = 0 AND l.objid = $1 AND l.objsubid = 1", migrationLockKey).Scan(&name, &address, &pid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	holder := name.String
+	if holder == "" {
+		holder = "unnamed connection"
+	}
+	if address.Valid {
+		holder += " at " + address.String
+	}
+
+	return holder + " (pid " + strconv.Itoa(pid) + ")", nil
+}
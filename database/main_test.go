@@ -0,0 +1,25 @@
+package database_test
+
+import (
+	"GoWeb/database"
+	"GoWeb/testsupport"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	testsupport.Main(m)
+}
+
+// migrate creates the tables of models only used by tests in the throwaway schema, like Migrate only fields that
+// aren't zero in the dummies get a column
+func migrate(t *testing.T, dummies ...any) {
+	t.Helper()
+
+	app := testsupport.App(t)
+	for _, dummy := range dummies {
+		err := database.Migrate(app, dummy)
+		if err != nil {
+			t.Fatal("error migrating test model: " + err.Error())
+		}
+	}
+}
@@ -2,21 +2,28 @@ package database
 
 import (
 	"GoWeb/app"
+	"context"
 	"errors"
 	"fmt"
-	"github.com/lib/pq"
 	"log/slog"
 	"reflect"
+	"strings"
 )
 
 // Migrate given a dummy object of any type, it will create a table with the same name
-// as the type and create columns with the same name as the fields of the object
+// as the type and create columns with the same name as the fields of the object. The primary key
+// columns are created with the table and indexes declared on fields together with their columns
 func Migrate(app *app.App, anyStruct interface{}) error {
 	valueOfStruct := reflect.ValueOf(anyStruct)
 	typeOfStruct := valueOfStruct.Type()
 
+	m, err := modelOf(typeOfStruct)
+	if err != nil {
+		return err
+	}
+
 	tableName := typeOfStruct.Name()
-	err := createTable(app, tableName)
+	err = createTable(app, tableName, typeOfStruct, m)
 	if err != nil {
 		return err
 	}
@@ -28,22 +35,49 @@ func Migrate(app *app.App, anyStruct interface{}) error {
 		// Create column if dummy for migration is NOT zero value
 		fieldValue := valueOfStruct.Field(i).Interface()
 		if !reflect.ValueOf(fieldValue).IsZero() {
-			if fieldName != "Id" && fieldName != "id" {
+			if !m.isKey(i) && !m.isRelation(i) {
 				err := createColumn(app, tableName, fieldName, fieldType.Type.Name())
 				if err != nil {
 					return err
 				}
+
+				if idx, ok := m.indexOn(fieldName); ok {
+					err = createIndex(app, tableName, idx)
+					if err != nil {
+						return err
+					}
+				}
 			}
 		}
 	}
 
+	// Create the full-text search column if any field is tagged as searchable
+	if len(m.searchable) > 0 {
+		err = createSearchColumn(app, tableName, m.searchable)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// createTable creates a table with the given name if it doesn't exist, it is assumed that id will be the primary key
-func createTable(app *app.App, tableName string) error {
-	var tableExists bool
-	err := app.Db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace WHERE c.relname ~ $1 AND pg_catalog.pg_table_is_visible(c.oid))", "^"+tableName+"$").Scan(&tableExists)
+// createTable creates a table with the given name in the configured schema if it doesn't exist, together with the
+// primary key columns of the model. The schema itself is created first if needed and the dialect supports schemas
+func createTable(app *app.App, tableName string, typeOfStruct reflect.Type, m *model) error {
+	ctx := context.Background()
+	d := DialectOf(app)
+	schema := Schema(ctx, app)
+
+	if d.SupportsSchemas() {
+		_, err := app.Db.Exec("CREATE SCHEMA IF NOT EXISTS " + d.QuoteIdentifier(schema))
+		if err != nil {
+			slog.Error("error creating schema: " + schema)
+			return err
+		}
+	}
+
+	tableExists, err := d.TableExists(ctx, app.Db, schema, tableName)
 	if err != nil {
 		slog.Error("error checking if table exists: " + tableName)
 		return err
@@ -53,9 +87,15 @@ func createTable(app *app.App, tableName string) error {
 		slog.Info("table already exists: " + tableName)
 		return nil
 	} else {
-		sanitizedTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS \"%s\" (\"Id\" serial primary key)", tableName)
+		definitions, err := keyDefinitions(d, typeOfStruct, m)
+		if err != nil {
+			slog.Error("error creating primary key of table: " + tableName)
+			return err
+		}
 
-		_, err := app.Db.Query(sanitizedTableQuery)
+		sanitizedTableQuery := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", tableIn(ctx, app, tableName), strings.Join(definitions, ", "))
+
+		_, err = app.Db.Exec(sanitizedTableQuery)
 		if err != nil {
 			slog.Error("error creating table: " + tableName)
 			return err
@@ -66,10 +106,49 @@ func createTable(app *app.App, tableName string) error {
 	}
 }
 
+// keyDefinitions returns the definitions of the primary key columns of the model followed by the key constraint,
+// a model without primary key gets a serial Id column
+func keyDefinitions(d Dialect, typeOfStruct reflect.Type, m *model) ([]string, error) {
+	if !m.hasKey() {
+		return []string{d.AutoIncrement("Id", false)}, nil
+	}
+
+	if m.autoIncrements() {
+		return []string{d.AutoIncrement(m.keyColumns[0], m.key == keyBigSerial)}, nil
+	}
+
+	var definitions, quoted []string
+	for i, column := range m.keyColumns {
+		var keyType string
+		switch m.key {
+		case keyUUID:
+			keyType = d.UUIDType()
+			if d.UUIDDefault() != "" {
+				keyType += " DEFAULT " + d.UUIDDefault()
+			}
+		case keyUUIDv4, keyUUIDv7:
+			keyType = d.UUIDType()
+		default:
+			var err error
+			keyType, err = d.KeyColumnType(typeOfStruct.Field(m.keys[i]).Type.Name())
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		quoted = append(quoted, d.QuoteIdentifier(column))
+		definitions = append(definitions, d.QuoteIdentifier(column)+" "+keyType+" NOT NULL")
+	}
+
+	return append(definitions, "PRIMARY KEY ("+strings.Join(quoted, ", ")+")"), nil
+}
+
 // createColumn creates a column with the given name and type if it doesn't exist
 func createColumn(app *app.App, tableName, columnName, columnType string) error {
-	var columnExists bool
-	err := app.Db.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = $1 AND column_name = $2)", tableName, columnName).Scan(&columnExists)
+	ctx := context.Background()
+	d := DialectOf(app)
+
+	columnExists, err := d.ColumnExists(ctx, app.Db, Schema(ctx, app), tableName, columnName)
 	if err != nil {
 		slog.Error("error checking if column exists: " + columnName + " in table: " + tableName)
 		return err
@@ -79,22 +158,22 @@ func createColumn(app *app.App, tableName, columnName, columnType string) error
 		slog.Info("column already exists: " + columnName + " in table: " + tableName)
 		return nil
 	} else {
-		postgresType, err := getPostgresType(columnType)
+		sqlType, err := d.ColumnType(columnType)
 		if err != nil {
-			slog.Error("error creating column: " + columnName + " in table: " + tableName + " with type: " + postgresType)
+			slog.Error("error creating column: " + columnName + " in table: " + tableName + " with type: " + columnType)
 			return err
 		}
 
-		sanitizedTableName := pq.QuoteIdentifier(tableName)
-		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS \"%s\" %s", sanitizedTableName, columnName, postgresType)
+		sanitizedTableName := tableIn(ctx, app, tableName)
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", sanitizedTableName, d.QuoteIdentifier(columnName), sqlType)
 
-		_, err = app.Db.Query(query)
+		_, err = app.Db.Exec(query)
 		if err != nil {
-			slog.Error("error creating column: " + columnName + " in table: " + tableName + " with type: " + postgresType)
+			slog.Error("error creating column: " + columnName + " in table: " + tableName + " with type: " + sqlType)
 			return err
 		}
 
-		slog.Info("column created successfully:", columnName)
+		slog.Info("column created successfully: " + columnName)
 
 		return nil
 	}
@@ -105,19 +184,19 @@ func getPostgresType(goType string) (string, error) {
 	switch goType {
 	case "int", "int32", "uint", "uint32":
 		return "integer", nil
-	case "int64", "uint64":
+	case "int64", "uint64", "NullInt64":
 		return "bigint", nil
 	case "int16", "int8", "uint16", "uint8", "byte":
 		return "smallint", nil
-	case "string":
+	case "string", "NullString":
 		return "text", nil
 	case "float64":
 		return "double precision", nil
-	case "bool":
+	case "bool", "NullBool":
 		return "boolean", nil
-	case "Time":
+	case "Time", "NullTime":
 		return "timestamp", nil
-	case "[]byte":
+	case "[]byte", "EncryptedString":
 		return "bytea", nil
 	}
 
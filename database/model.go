@@ -0,0 +1,364 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// model describes how a struct type maps onto its table, it is built once per type by reflection. Fields named
+// CreatedAt, UpdatedAt and DeletedAt are maintained by the data layer, a DeletedAt field (sql.NullTime) turns deletes
+// into soft deletes and an integer Version field enables optimistic locking on updates. The primary key is made of
+// the fields tagged db:"pk", or db:"pk,<strategy>" to have it generated, and defaults to a serial Id field. Fields
+// tagged as relationships aren't columns, they hold related rows loaded on request, fields tagged index:"" get an index
+type model struct {
+	table      string               // Table name, the same as the struct type name just like Migrate uses
+	columns    []string             // Column names excluding the primary key, in struct field order
+	fields     []int                // Struct field index of each entry in columns
+	keys       []int                // Struct field indexes of the primary key, empty if the struct has none
+	keyColumns []string             // Column name of each entry in keys
+	key        keyStrategy          // How the value of a single column primary key is generated
+	createdAt  int                  // Struct field index of CreatedAt, -1 if the struct has none
+	updatedAt  int                  // Struct field index of UpdatedAt, -1 if the struct has none
+	deletedAt  int                  // Struct field index of DeletedAt, -1 if the struct has none
+	version    int                  // Struct field index of Version, -1 if the struct has none
+	redacted   map[int]bool         // Struct field indexes tagged audit:"redact" or encrypted, kept out of the audit trail
+	searchable []searchField        // Text fields tagged search:"<weight>", indexed into the SearchVector column
+	relations  map[string]*relation // Relationship fields by name, see relation
+	indexes    []index              // Secondary indexes declared with index:"", see index
+}
+
+// keyStrategy is how the value of a primary key is produced, it is chosen with db:"pk,<strategy>"
+type keyStrategy string
+
+const (
+	keyNatural   keyStrategy = ""          // Set by the application, e.g. a code or a composite key of a join table
+	keySerial    keyStrategy = "serial"    // Auto-incrementing integer assigned by the database
+	keyBigSerial keyStrategy = "bigserial" // 64 bit auto-incrementing integer assigned by the database
+	keyUUID      keyStrategy = "uuid"      // Random UUID assigned by the database, or in Go if the database can't
+	keyUUIDv4    keyStrategy = "uuidv4"    // Random UUID generated in Go
+	keyUUIDv7    keyStrategy = "uuidv7"    // Time ordered UUID generated in Go, keeps index inserts local
+)
+
+// searchField is a column included in full-text search with its weight, A ranks highest and D lowest
+type searchField struct {
+	column string
+	weight string
+}
+
+var models sync.Map // Maps reflect.Type to *model
+
+// modelOf returns the table mapping of the given struct type
+func modelOf(typeOfStruct reflect.Type) (*model, error) {
+	if cached, ok := models.Load(typeOfStruct); ok {
+		return cached.(*model), nil
+	}
+
+	if typeOfStruct.Kind() != reflect.Struct {
+		return nil, errors.New("model must be a struct, got: " + typeOfStruct.String())
+	}
+
+	m := &model{table: typeOfStruct.Name(), createdAt: -1, updatedAt: -1, deletedAt: -1, version: -1}
+	err := m.mapKeys(typeOfStruct)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < typeOfStruct.NumField(); i++ {
+		field := typeOfStruct.Field(i)
+		if !field.IsExported() || m.isKey(i) {
+			continue
+		}
+
+		rel, ok, err := parseRelation(typeOfStruct, i)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if m.relations == nil {
+				m.relations = make(map[string]*relation)
+			}
+			m.relations[field.Name] = rel
+			continue
+		}
+
+		switch field.Name {
+		case "CreatedAt":
+			m.createdAt = i
+		case "UpdatedAt":
+			m.updatedAt = i
+		case "DeletedAt":
+			m.deletedAt = i
+		case "Version":
+			if field.Type.Kind() != reflect.Int64 && field.Type.Kind() != reflect.Int {
+				return nil, errors.New("Version field of " + typeOfStruct.Name() + " must be an int or int64")
+			}
+			m.version = i
+		}
+
+		if field.Tag.Get("audit") == "redact" || field.Type == encryptedStringType {
+			if m.redacted == nil {
+				m.redacted = make(map[int]bool)
+			}
+			m.redacted[i] = true
+		}
+
+		if weight, ok := field.Tag.Lookup("search"); ok {
+			if field.Type.Kind() != reflect.String {
+				return nil, errors.New("searchable field " + field.Name + " of " + typeOfStruct.Name() + " must be a string")
+			}
+
+			weight = strings.ToUpper(weight)
+			if weight == "" {
+				weight = "D"
+			}
+			if weight != "A" && weight != "B" && weight != "C" && weight != "D" {
+				return nil, errors.New("search weight of " + field.Name + " must be A, B, C or D")
+			}
+
+			m.searchable = append(m.searchable, searchField{column: field.Name, weight: weight})
+		}
+
+		idx, ok, err := parseIndex(typeOfStruct, field)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			m.indexes = append(m.indexes, idx)
+		}
+
+		m.columns = append(m.columns, field.Name)
+		m.fields = append(m.fields, i)
+	}
+
+	models.Store(typeOfStruct, m)
+	return m, nil
+}
+
+// mapKeys finds the primary key of the struct type, the fields tagged db:"pk" or else a field named Id which is then
+// a serial key
+func (m *model) mapKeys(typeOfStruct reflect.Type) error {
+	for i := 0; i < typeOfStruct.NumField(); i++ {
+		field := typeOfStruct.Field(i)
+		options := strings.Split(field.Tag.Get("db"), ",")
+		if options[0] != "pk" || !field.IsExported() {
+			continue
+		}
+
+		m.keys = append(m.keys, i)
+		m.keyColumns = append(m.keyColumns, field.Name)
+		if len(options) > 1 {
+			m.key = keyStrategy(options[1])
+		}
+	}
+
+	if len(m.keys) == 0 {
+		for i := 0; i < typeOfStruct.NumField(); i++ {
+			if name := typeOfStruct.Field(i).Name; name == "Id" || name == "id" {
+				m.keys, m.keyColumns, m.key = []int{i}, []string{name}, keySerial
+			}
+		}
+	}
+
+	if m.key != keyNatural && len(m.keys) > 1 {
+		return errors.New("composite primary key of " + m.table + " can't be generated, set its values instead")
+	}
+
+	for _, field := range m.keys {
+		kind := typeOfStruct.Field(field).Type.Kind()
+		switch m.key {
+		case keyNatural:
+		case keySerial, keyBigSerial:
+			if kind != reflect.Int && kind != reflect.Int64 && kind != reflect.Int32 {
+				return errors.New(string(m.key) + " primary key of " + m.table + " must be an integer")
+			}
+		case keyUUID, keyUUIDv4, keyUUIDv7:
+			if kind != reflect.String {
+				return errors.New(string(m.key) + " primary key of " + m.table + " must be a string")
+			}
+		default:
+			return errors.New("unknown primary key strategy of " + m.table + ": " + string(m.key))
+		}
+	}
+
+	return nil
+}
+
+// isRelation reports whether the struct field index is a relationship rather than a column
+func (m *model) isRelation(field int) bool {
+	for _, rel := range m.relations {
+		if rel.field == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isKey reports whether the struct field index is part of the primary key
+func (m *model) isKey(field int) bool {
+	for _, key := range m.keys {
+		if key == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasKey reports whether rows of the model can be addressed by a primary key
+func (m *model) hasKey() bool {
+	return len(m.keys) > 0
+}
+
+// autoIncrements reports whether the primary key is an auto-incrementing integer
+func (m *model) autoIncrements() bool {
+	return m.key == keySerial || m.key == keyBigSerial
+}
+
+// databaseGeneratesKey reports whether the database assigns the primary key on insert
+func (m *model) databaseGeneratesKey(d Dialect) bool {
+	return m.autoIncrements() || (m.key == keyUUID && d.UUIDDefault() != "")
+}
+
+// generateKey sets a zero primary key that is generated in Go
+func (m *model) generateKey(d Dialect, value reflect.Value) error {
+	if len(m.keys) != 1 || !value.Field(m.keys[0]).IsZero() || m.databaseGeneratesKey(d) {
+		return nil
+	}
+
+	var id string
+	var err error
+	switch m.key {
+	case keyUUID, keyUUIDv4:
+		id, err = NewUUIDv4()
+	case keyUUIDv7:
+		id, err = NewUUIDv7()
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	value.Field(m.keys[0]).SetString(id)
+	return nil
+}
+
+// keyCondition returns the condition matching a row by its primary key, with a ? placeholder per key column
+func (m *model) keyCondition(d Dialect) string {
+	conditions := make([]string, len(m.keyColumns))
+	for i, column := range m.keyColumns {
+		conditions[i] = d.QuoteIdentifier(column) + " = ?"
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+// keyValues returns the value of every primary key field of value, matching keyCondition
+func (m *model) keyValues(value reflect.Value) []any {
+	values := make([]any, len(m.keys))
+	for i, field := range m.keys {
+		values[i] = value.Field(field).Interface()
+	}
+
+	return values
+}
+
+// keyOf returns the primary key of value, the value itself for single column keys and a slice for composite ones
+func (m *model) keyOf(value reflect.Value) any {
+	if len(m.keys) == 1 {
+		return value.Field(m.keys[0]).Interface()
+	}
+
+	return m.keyValues(value)
+}
+
+// rowId formats the primary key of value as text, the values of composite keys are separated by commas
+func (m *model) rowId(value reflect.Value) string {
+	parts := make([]string, len(m.keys))
+	for i, key := range m.keyValues(value) {
+		parts[i] = fmt.Sprint(key)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// modelValue checks that ptr is a pointer to a struct and returns the struct value together with its mapping
+func modelValue(ptr any) (reflect.Value, *model, error) {
+	value := reflect.ValueOf(ptr)
+	if value.Kind() != reflect.Pointer || value.IsNil() {
+		return reflect.Value{}, nil, errors.New("model must be a non-nil pointer to a struct")
+	}
+
+	m, err := modelOf(value.Elem().Type())
+	if err != nil {
+		return reflect.Value{}, nil, err
+	}
+
+	return value.Elem(), m, nil
+}
+
+// qualifiedTable returns the quoted table name of the model qualified with the schema queries made with ctx use
+func (m *model) qualifiedTable(ctx context.Context, app *app.App) string {
+	return tableIn(ctx, app, m.table)
+}
+
+// tableIn returns the quoted name of a table qualified with the schema queries made with ctx use, the name is left
+// unqualified on dialects without schemas
+func tableIn(ctx context.Context, app *app.App, table string) string {
+	d := DialectOf(app)
+	if !d.SupportsSchemas() {
+		return d.QuoteIdentifier(table)
+	}
+
+	return qualifiedTable(Schema(ctx, app), table)
+}
+
+// selectColumns returns the quoted, comma separated list of every column starting with the primary key, matching
+// scanTargets
+func (m *model) selectColumns(d Dialect) string {
+	var quoted []string
+	for _, column := range m.keyColumns {
+		quoted = append(quoted, d.QuoteIdentifier(column))
+	}
+
+	for _, column := range m.columns {
+		quoted = append(quoted, d.QuoteIdentifier(column))
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+// scanTargets returns pointers to every field of value in the order of selectColumns
+func (m *model) scanTargets(value reflect.Value) []any {
+	var targets []any
+	for _, field := range m.keys {
+		targets = append(targets, value.Field(field).Addr().Interface())
+	}
+
+	for _, field := range m.fields {
+		targets = append(targets, value.Field(field).Addr().Interface())
+	}
+
+	return targets
+}
+
+// values returns the value of every column of the model in the same order as columns
+func (m *model) values(value reflect.Value) []any {
+	values := make([]any, len(m.fields))
+	for i, field := range m.fields {
+		values[i] = value.Field(field).Interface()
+	}
+
+	return values
+}
+
+// softDeletes reports whether deleting a row of the model only sets DeletedAt
+func (m *model) softDeletes() bool {
+	return m.deletedAt >= 0
+}
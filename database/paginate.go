@@ -0,0 +1,204 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// Page is one page of query results, its fields are exported with JSON names so handlers can encode it directly and
+// templates can use HasNext/HasPrev to decide which links to show
+type Page[T any] struct {
+	Items   []T `json:"items"`
+	PerPage int `json:"perPage"`
+
+	// Offset pagination only
+	Page       int   `json:"page,omitempty"`       // Current page, starting at 1
+	TotalPages int   `json:"totalPages,omitempty"` // Number of pages needed for Total items
+	Total      int64 `json:"total,omitempty"`      // Number of rows matching the query
+
+	// Keyset pagination only, pass a cursor back to PaginateKeyset to fetch the neighbouring page
+	NextCursor string `json:"nextCursor,omitempty"`
+	PrevCursor string `json:"prevCursor,omitempty"`
+
+	HasNext bool `json:"hasNext"`
+	HasPrev bool `json:"hasPrev"`
+}
+
+// Keyset describes the ordering keyset pagination walks along, the primary key is used to break ties between equal
+// values
+type Keyset struct {
+	Column     string // Field of the model to order by
+	Descending bool
+}
+
+// cursor is the decoded form of the opaque cursors handed out by PaginateKeyset
+type cursor struct {
+	Value    any  `json:"v"`
+	Id       any  `json:"id"`
+	Backward bool `json:"b,omitempty"`
+}
+
+// ErrInvalidCursor is returned when a cursor can't be decoded, usually because it was altered by the client
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+// Paginate returns the given page of matching rows, pages start at 1, along with the total number of matching rows.
+// The query's own Limit and Offset are replaced
+func (q *Query[T]) Paginate(ctx context.Context, page int, perPage int) (Page[T], error) {
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		return Page[T]{}, errors.New("perPage must be at least 1")
+	}
+
+	total, err := q.clone().Count(ctx)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	items, err := q.clone().Limit(perPage).Offset((page - 1) * perPage).All(ctx)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	totalPages := int((total + int64(perPage) - 1) / int64(perPage))
+	return Page[T]{
+		Items:      items,
+		PerPage:    perPage,
+		Page:       page,
+		TotalPages: totalPages,
+		Total:      total,
+		HasNext:    page < totalPages,
+		HasPrev:    page > 1,
+	}, nil
+}
+
+// PaginateKeyset returns the page of matching rows following or preceding the position encoded in cursor, an empty
+// cursor returns the first page. Unlike offset pagination the cost doesn't grow with the page number and rows
+// inserted meanwhile don't shift results between pages. The query's own OrderBy, Limit and Offset are replaced
+func (q *Query[T]) PaginateKeyset(ctx context.Context, keyset Keyset, encodedCursor string, perPage int) (Page[T], error) {
+	if perPage < 1 {
+		return Page[T]{}, errors.New("perPage must be at least 1")
+	}
+
+	m, err := q.model()
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	if len(m.keys) != 1 {
+		return Page[T]{}, errors.New("keyset pagination requires " + m.table + " to have a single column primary key")
+	}
+
+	var position *cursor
+	if encodedCursor != "" {
+		position, err = decodeCursor(encodedCursor)
+		if err != nil {
+			return Page[T]{}, err
+		}
+	}
+
+	backward := position != nil && position.Backward
+	descending := keyset.Descending != backward // Walking backwards reverses the order rows are fetched in
+
+	d := DialectOf(q.app)
+	column := d.QuoteIdentifier(keyset.Column)
+	key := d.QuoteIdentifier(m.keyColumns[0])
+	direction, comparison := " ASC", " > "
+	if descending {
+		direction, comparison = " DESC", " < "
+	}
+
+	page := q.clone().OrderBy(column + direction + ", " + key + direction).Limit(perPage + 1)
+	if position != nil {
+		page.Where("("+column+", "+key+")"+comparison+"(?, ?)", position.Value, position.Id)
+	}
+
+	items, err := page.All(ctx)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	more := len(items) > perPage
+	if more {
+		items = items[:perPage]
+	}
+
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	result := Page[T]{Items: items, PerPage: perPage}
+	if backward {
+		result.HasNext, result.HasPrev = true, more
+	} else {
+		result.HasNext, result.HasPrev = more, position != nil
+	}
+
+	if len(items) > 0 {
+		if result.HasNext {
+			result.NextCursor, err = encodeCursor(items[len(items)-1], m, keyset.Column, false)
+			if err != nil {
+				return Page[T]{}, err
+			}
+		}
+		if result.HasPrev {
+			result.PrevCursor, err = encodeCursor(items[0], m, keyset.Column, true)
+			if err != nil {
+				return Page[T]{}, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// clone returns a copy of the query that can be changed without affecting the original
+func (q *Query[T]) clone() *Query[T] {
+	clone := *q
+	clone.conditions = append([]string(nil), q.conditions...)
+	clone.args = append([]any(nil), q.args...)
+	clone.with = append([]string(nil), q.with...)
+	return &clone
+}
+
+// encodeCursor encodes the position of item into an opaque URL safe cursor
+func encodeCursor(item any, m *model, column string, backward bool) (string, error) {
+	value := reflect.ValueOf(item)
+	field := value.FieldByName(column)
+	if !field.IsValid() {
+		return "", errors.New("keyset column " + column + " is not a field of " + m.table)
+	}
+
+	encoded, err := json.Marshal(cursor{Value: field.Interface(), Id: m.keyOf(value), Backward: backward})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+// decodeCursor decodes a cursor created by encodeCursor, numbers are kept as json.Number so large ids keep precision
+func decodeCursor(encoded string) (*cursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(decoded))
+	decoder.UseNumber()
+
+	var position cursor
+	err = decoder.Decode(&position)
+	if err != nil || position.Id == nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &position, nil
+}
@@ -0,0 +1,135 @@
+package database_test
+
+import (
+	"GoWeb/database"
+	"GoWeb/testsupport"
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Entry is a test model ordered by a column with ties
+type Entry struct {
+	Id        int64
+	Rank      int64
+	CreatedAt time.Time
+}
+
+// insertEntries stores entries with the given ranks and returns their ids ordered by rank, ties by id
+func insertEntries(t *testing.T, ctx context.Context, ranks ...int64) []int64 {
+	t.Helper()
+
+	migrate(t, Entry{Id: 1, Rank: 1, CreatedAt: time.Now()})
+	app := testsupport.App(t)
+
+	entries := make([]Entry, len(ranks))
+	for i, rank := range ranks {
+		entries[i].Rank = rank
+		err := database.Insert(ctx, app, &entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return cmp.Or(cmp.Compare(a.Rank, b.Rank), cmp.Compare(a.Id, b.Id))
+	})
+
+	ids := make([]int64, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.Id
+	}
+	return ids
+}
+
+func idsOf(page database.Page[Entry]) []int64 {
+	ids := make([]int64, len(page.Items))
+	for i, entry := range page.Items {
+		ids[i] = entry.Id
+	}
+	return ids
+}
+
+func TestPaginateKeysetWalksForwardAndBack(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	want := insertEntries(t, ctx, 2, 1, 2, 3, 2)
+	keyset := database.Keyset{Column: "Rank"}
+
+	var pages []database.Page[Entry]
+	var got []int64
+	cursor := ""
+	for {
+		page, err := database.From[Entry](app).PaginateKeyset(ctx, keyset, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pages = append(pages, page)
+		got = append(got, idsOf(page)...)
+		if !page.HasNext {
+			break
+		}
+		if len(pages) > len(want) {
+			t.Fatal("pagination did not end")
+		}
+		cursor = page.NextCursor
+	}
+
+	if !slices.Equal(got, want) {
+		t.Fatalf("walking forward got %v, want %v", got, want)
+	}
+	if len(pages) != 3 || pages[0].HasPrev || !pages[2].HasPrev {
+		t.Fatalf("got %d pages, HasPrev of the first %v and the last %v, want 3 pages with only the first lacking a previous one", len(pages), pages[0].HasPrev, pages[2].HasPrev)
+	}
+
+	for i := len(pages) - 1; i > 0; i-- {
+		previous, err := database.From[Entry](app).PaginateKeyset(ctx, keyset, pages[i].PrevCursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(idsOf(previous), idsOf(pages[i-1])) {
+			t.Fatalf("walking back from page %d got %v, want %v", i+1, idsOf(previous), idsOf(pages[i-1]))
+		}
+		if !previous.HasNext || previous.HasPrev != (i > 1) {
+			t.Fatalf("walking back to page %d got HasNext %v and HasPrev %v", i, previous.HasNext, previous.HasPrev)
+		}
+	}
+}
+
+func TestPaginateKeysetDescending(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	want := insertEntries(t, ctx, 2, 1, 2, 3, 2)
+	slices.Reverse(want)
+
+	page, err := database.From[Entry](app).PaginateKeyset(ctx, database.Keyset{Column: "Rank", Descending: true}, "", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(idsOf(page), want[:3]) || !page.HasNext || page.HasPrev {
+		t.Fatalf("got %v with HasNext %v and HasPrev %v, want %v with only a next page", idsOf(page), page.HasNext, page.HasPrev, want[:3])
+	}
+
+	next, err := database.From[Entry](app).PaginateKeyset(ctx, database.Keyset{Column: "Rank", Descending: true}, page.NextCursor, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(idsOf(next), want[3:]) || next.HasNext {
+		t.Fatalf("got %v with HasNext %v, want the last page %v", idsOf(next), next.HasNext, want[3:])
+	}
+}
+
+func TestPaginateKeysetRejectsAlteredCursor(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	insertEntries(t, ctx, 1)
+
+	_, err := database.From[Entry](app).PaginateKeyset(ctx, database.Keyset{Column: "Rank"}, "not a cursor", 2)
+	if !errors.Is(err, database.ErrInvalidCursor) {
+		t.Fatalf("got %v, want ErrInvalidCursor", err)
+	}
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// pubSub holds the LISTEN connection of an app and the handlers notifications are delivered to
+type pubSub struct {
+	listener    *pq.Listener
+	mu          sync.RWMutex
+	handlers    map[string][]func(ctx context.Context, payload []byte)
+	onReconnect []func()
+	done        chan struct{}
+}
+
+// maxNotifyPayload is the largest payload Postgres accepts for a notification
+const maxNotifyPayload = 8000
+
+// listenerPingInterval is how long an idle listener waits before checking that its connection is still alive
+const listenerPingInterval = 90 * time.Second
+
+var pubSubs sync.Map // Maps *app.App to its *pubSub, created on the first subscription
+
+// Publish sends payload encoded as JSON to every subscriber of channel on every instance connected to the database.
+// Published inside a transaction the notification is only delivered once the transaction commits
+func Publish(ctx context.Context, app *app.App, channel string, payload any) error {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if !isPostgres(app) {
+		return unsupported(app, "pub-sub")
+	}
+
+	if len(encoded) >= maxNotifyPayload {
+		return errors.New("notification payload for channel " + channel + " is too large, send an id and load the data instead")
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx, app)
+	defer cancel()
+
+	_, err = Writer(ctx, app).ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, string(encoded))
+	return err
+}
+
+// Subscribe calls handler with the decoded payload of every notification published on channel, including ones
+// published by this instance. Handlers of a channel run one at a time in the order notifications arrive. The
+// listening connection reconnects by itself after failures, notifications sent while it was down are lost, use
+// OnReconnect to resynchronise
+func Subscribe[T any](app *app.App, channel string, handler func(ctx context.Context, payload T)) error {
+	if !isPostgres(app) {
+		return unsupported(app, "pub-sub")
+	}
+
+	ps := pubSubOf(app)
+
+	// Only register the handler once the channel is listened on so a failed subscription leaves nothing behind,
+	// listening again on an open channel is refused by the listener without a round trip
+	err := ps.listener.Listen(channel)
+	if err != nil && !errors.Is(err, pq.ErrChannelAlreadyOpen) {
+		slog.Error("error listening on channel", "channel", channel, "error", err)
+		return err
+	}
+
+	ps.mu.Lock()
+	ps.handlers[channel] = append(ps.handlers[channel], func(ctx context.Context, payload []byte) {
+		var decoded T
+		err := json.Unmarshal(payload, &decoded)
+		if err != nil {
+			slog.Error("error decoding notification payload", "channel", channel, "error", err)
+			return
+		}
+
+		handler(ctx, decoded)
+	})
+	ps.mu.Unlock()
+
+	return nil
+}
+
+// OnReconnect registers a function called after the listening connection was re-established, since notifications
+// sent while it was down are lost this is the place to drop caches that they would have invalidated
+func OnReconnect(app *app.App, f func()) {
+	ps := pubSubOf(app)
+
+	ps.mu.Lock()
+	ps.onReconnect = append(ps.onReconnect, f)
+	ps.mu.Unlock()
+}
+
+// ClosePubSub stops delivering notifications and closes the listening connection of the app, if it has one
+func ClosePubSub(app *app.App) error {
+	loaded, ok := pubSubs.LoadAndDelete(app)
+	if !ok {
+		return nil
+	}
+
+	ps := loaded.(*pubSub)
+	close(ps.done)
+	return ps.listener.Close()
+}
+
+// pubSubOf returns the pub-sub state of the app, opening its listening connection on first use
+func pubSubOf(app *app.App) *pubSub {
+	if loaded, ok := pubSubs.Load(app); ok {
+		return loaded.(*pubSub)
+	}
+
+	ps := &pubSub{
+		handlers: make(map[string][]func(ctx context.Context, payload []byte)),
+		done:     make(chan struct{}),
+	}
+
+	ps.listener = pq.NewListener(connectionString(app), time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventConnectionAttemptFailed:
+			slog.Warn("pub-sub listener failed to connect, retrying", "error", err)
+		case pq.ListenerEventDisconnected:
+			slog.Warn("pub-sub listener disconnected, reconnecting", "error", err)
+		case pq.ListenerEventReconnected:
+			slog.Info("pub-sub listener reconnected")
+		}
+	})
+
+	loaded, raced := pubSubs.LoadOrStore(app, ps)
+	if raced {
+		_ = ps.listener.Close()
+		return loaded.(*pubSub)
+	}
+
+	go ps.dispatch()
+	return ps
+}
+
+// dispatch delivers notifications to the handlers of their channel until the pub-sub is closed
+func (ps *pubSub) dispatch() {
+	for {
+		select {
+		case <-ps.done:
+			return
+		case notification := <-ps.listener.Notify:
+			if notification == nil {
+				// A nil notification means the connection was re-established and notifications may have been missed
+				ps.mu.RLock()
+				callbacks := ps.onReconnect
+				ps.mu.RUnlock()
+
+				for _, f := range callbacks {
+					f()
+				}
+				continue
+			}
+
+			ps.mu.RLock()
+			handlers := ps.handlers[notification.Channel]
+			ps.mu.RUnlock()
+
+			for _, handler := range handlers {
+				ps.deliver(notification.Channel, handler, []byte(notification.Extra))
+			}
+		case <-time.After(listenerPingInterval):
+			// Nothing arrived for a while, make sure the connection hasn't silently died
+			go func() {
+				err := ps.listener.Ping()
+				if err != nil {
+					slog.Warn("pub-sub listener ping failed", "error", err)
+				}
+			}()
+		}
+	}
+}
+
+// deliver calls a handler, recovering from panics so one faulty handler can't stop delivery to the others
+func (ps *pubSub) deliver(channel string, handler func(ctx context.Context, payload []byte), payload []byte) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			slog.Error("notification handler panicked", "channel", channel, "panic", recovered)
+		}
+	}()
+
+	handler(context.Background(), payload)
+}
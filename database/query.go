@@ -0,0 +1,299 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query builds a SELECT or bulk DELETE against the table of the model T, conditions use ? placeholders which are
+// numbered when the query is run. Soft deleted rows are excluded unless WithTrashed or OnlyTrashed is used
+type Query[T any] struct {
+	app         *app.App
+	conditions  []string
+	args        []any
+	orderBy     string
+	limit       int
+	offset      int
+	withTrashed bool
+	onlyTrashed bool
+	with        []string
+	forUpdate   bool
+}
+
+// From starts a query against the table of the model T
+func From[T any](app *app.App) *Query[T] {
+	return &Query[T]{app: app}
+}
+
+// Find returns the row of the model T with the given primary key, composite keys are given in field order.
+// sql.ErrNoRows is returned if it doesn't exist or is soft deleted
+func Find[T any](ctx context.Context, app *app.App, key ...any) (T, error) {
+	var result T
+	m, err := modelOf(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return result, err
+	}
+
+	if !m.hasKey() || len(key) != len(m.keys) {
+		return result, fmt.Errorf("%s has a primary key of %d columns, got %d values", m.table, len(m.keys), len(key))
+	}
+
+	return From[T](app).Where(m.keyCondition(DialectOf(app)), key...).First(ctx)
+}
+
+// Where adds a condition that rows must match, multiple conditions are combined with AND. Each ? outside quotes is
+// a placeholder for the next arg, write ?? for operators spelled with a question mark such as jsonb's ?
+func (q *Query[T]) Where(condition string, args ...any) *Query[T] {
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, args...)
+	return q
+}
+
+// OrderBy sets the ORDER BY clause, e.g. "\"CreatedAt\" DESC"
+func (q *Query[T]) OrderBy(clause string) *Query[T] {
+	q.orderBy = clause
+	return q
+}
+
+// Limit sets the maximum number of rows returned
+func (q *Query[T]) Limit(limit int) *Query[T] {
+	q.limit = limit
+	return q
+}
+
+// Offset sets the number of rows skipped before rows are returned
+func (q *Query[T]) Offset(offset int) *Query[T] {
+	q.offset = offset
+	return q
+}
+
+// WithTrashed includes soft deleted rows in the results
+func (q *Query[T]) WithTrashed() *Query[T] {
+	q.withTrashed = true
+	return q
+}
+
+// OnlyTrashed restricts the results to soft deleted rows
+func (q *Query[T]) OnlyTrashed() *Query[T] {
+	q.onlyTrashed = true
+	return q
+}
+
+// With eager loads the named relationship fields of the returned rows, each with a single extra query
+func (q *Query[T]) With(relations ...string) *Query[T] {
+	q.with = append(q.with, relations...)
+	return q
+}
+
+// ForUpdate locks the returned rows until the transaction of ctx ends, so they can be checked and changed without a
+// concurrent transaction doing the same in between. The rows are read from the primary
+func (q *Query[T]) ForUpdate() *Query[T] {
+	q.forUpdate = true
+	return q
+}
+
+// All returns every matching row
+func (q *Query[T]) All(ctx context.Context) ([]T, error) {
+	m, err := q.model()
+	if err != nil {
+		return nil, err
+	}
+
+	d := DialectOf(q.app)
+	query := "SELECT " + m.selectColumns(d) + " FROM " + m.qualifiedTable(ctx, q.app) + q.where(m)
+	if q.orderBy != "" {
+		query += " ORDER BY " + q.orderBy
+	}
+	if q.limit > 0 {
+		query += " LIMIT " + strconv.Itoa(q.limit)
+	}
+	if q.offset > 0 {
+		query += " OFFSET " + strconv.Itoa(q.offset)
+	}
+
+	db := Reader(ctx, q.app)
+	if q.forUpdate {
+		query += d.LockForUpdate()
+		db = Writer(ctx, q.app)
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx, q.app)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, rebind(d, query), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []T
+	for rows.Next() {
+		var result T
+		err = rows.Scan(m.scanTargets(reflect.ValueOf(&result).Elem())...)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	err = rows.Err()
+	if err != nil || len(q.with) == 0 {
+		return results, err
+	}
+
+	return results, loadRelations(ctx, q.app, m, reflect.ValueOf(results), q.with)
+}
+
+// First returns the first matching row, sql.ErrNoRows is returned if there is none
+func (q *Query[T]) First(ctx context.Context) (T, error) {
+	var result T
+
+	q.limit = 1
+	results, err := q.All(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	if len(results) == 0 {
+		return result, sql.ErrNoRows
+	}
+
+	return results[0], nil
+}
+
+// Exists reports whether any row matches
+func (q *Query[T]) Exists(ctx context.Context) (bool, error) {
+	m, err := q.model()
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx, q.app)
+	defer cancel()
+
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM " + m.qualifiedTable(ctx, q.app) + q.where(m) + ")"
+	err = Reader(ctx, q.app).QueryRowContext(ctx, rebind(DialectOf(q.app), query), q.args...).Scan(&exists)
+	return exists, err
+}
+
+// Count returns the number of matching rows, ignoring Limit and Offset
+func (q *Query[T]) Count(ctx context.Context) (int64, error) {
+	m, err := q.model()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx, q.app)
+	defer cancel()
+
+	var count int64
+	query := "SELECT COUNT(*) FROM " + m.qualifiedTable(ctx, q.app) + q.where(m)
+	err = Reader(ctx, q.app).QueryRowContext(ctx, rebind(DialectOf(q.app), query), q.args...).Scan(&count)
+	return count, err
+}
+
+// Delete removes every matching row and returns how many were removed, models with a DeletedAt field are soft
+// deleted
+func (q *Query[T]) Delete(ctx context.Context) (int64, error) {
+	m, err := q.model()
+	if err != nil {
+		return 0, err
+	}
+
+	if !m.softDeletes() {
+		return q.ForceDelete(ctx)
+	}
+
+	if isAudited(new(T)) || hasDeleteHooks(new(T)) {
+		return q.eachRow(ctx, Delete)
+	}
+
+	q.withTrashed, q.onlyTrashed = false, false
+	query := "UPDATE " + m.qualifiedTable(ctx, q.app) + " SET \"DeletedAt\" = ?" + q.where(m)
+	args := append([]any{sql.NullTime{Time: time.Now(), Valid: true}}, q.args...)
+
+	return q.exec(ctx, query, args)
+}
+
+// ForceDelete permanently removes every matching row and returns how many were removed
+func (q *Query[T]) ForceDelete(ctx context.Context) (int64, error) {
+	m, err := q.model()
+	if err != nil {
+		return 0, err
+	}
+
+	if isAudited(new(T)) || hasDeleteHooks(new(T)) {
+		return q.eachRow(ctx, ForceDelete)
+	}
+
+	return q.exec(ctx, "DELETE FROM "+m.qualifiedTable(ctx, q.app)+q.where(m), q.args)
+}
+
+// eachRow applies a single row change to every matching row in one transaction, used by bulk changes of audited
+// models and models with hooks so every row gets its own audit entry and hook calls
+func (q *Query[T]) eachRow(ctx context.Context, change func(ctx context.Context, app *app.App, ptr any) error) (int64, error) {
+	var affected int64
+	err := WithTransaction(ctx, q.app, func(ctx context.Context) error {
+		rows, err := q.All(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i := range rows {
+			err = change(ctx, q.app, &rows[i])
+			if err != nil {
+				return err
+			}
+			affected++
+		}
+
+		return nil
+	})
+
+	return affected, err
+}
+
+// exec runs a statement with ? placeholders on the primary and returns the number of affected rows
+func (q *Query[T]) exec(ctx context.Context, query string, args []any) (int64, error) {
+	ctx, cancel := WithQueryTimeout(ctx, q.app)
+	defer cancel()
+
+	result, err := Writer(ctx, q.app).ExecContext(ctx, rebind(DialectOf(q.app), query), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// model returns the table mapping of T
+func (q *Query[T]) model() (*model, error) {
+	return modelOf(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// where builds the WHERE clause from the conditions and the soft delete filter, placeholders are left as ?
+func (q *Query[T]) where(m *model) string {
+	conditions := make([]string, 0, len(q.conditions)+1)
+	for _, condition := range q.conditions {
+		conditions = append(conditions, "("+condition+")")
+	}
+
+	if m.softDeletes() && q.onlyTrashed {
+		conditions = append(conditions, "\"DeletedAt\" IS NOT NULL")
+	} else if m.softDeletes() && !q.withTrashed {
+		conditions = append(conditions, "\"DeletedAt\" IS NULL")
+	}
+
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
@@ -0,0 +1,381 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// relationKind is how the rows of a relationship are linked, it is declared with db:"<kind>,fk=<column>"
+type relationKind string
+
+const (
+	belongsTo  relationKind = "belongsTo"  // The fk field of this model holds the key of one related row, e.g. Session.User
+	hasMany    relationKind = "hasMany"    // The fk column of the related rows holds the key of this model, e.g. User.Sessions
+	manyToMany relationKind = "manyToMany" // Rows of the join table link the fk key of this model to the ref key of related rows
+)
+
+// relation is a field of a model holding related rows, it isn't a column and is only filled by eager loading
+type relation struct {
+	kind       relationKind
+	field      int          // Struct field index of the relationship
+	target     reflect.Type // Struct type of the related model
+	foreignKey string       // Column holding the key of the other side, see relationKind
+	join       string       // Join table of many-to-many relationships
+	reference  string       // Column of the join table holding the key of the related rows
+}
+
+// parseRelation reads the relationship declared by the db tag of field, ok is false if it doesn't declare one
+func parseRelation(owner reflect.Type, index int) (*relation, bool, error) {
+	field := owner.Field(index)
+	options := strings.Split(field.Tag.Get("db"), ",")
+
+	rel := &relation{kind: relationKind(options[0]), field: index}
+	if rel.kind != belongsTo && rel.kind != hasMany && rel.kind != manyToMany {
+		return nil, false, nil
+	}
+
+	for _, option := range options[1:] {
+		name, value, _ := strings.Cut(option, "=")
+		switch name {
+		case "fk":
+			rel.foreignKey = value
+		case "join":
+			rel.join = value
+		case "ref":
+			rel.reference = value
+		default:
+			return nil, false, errors.New("unknown option " + name + " of relationship " + field.Name + " of " + owner.Name())
+		}
+	}
+
+	rel.target = field.Type
+	if rel.kind == belongsTo {
+		if rel.target.Kind() == reflect.Pointer {
+			rel.target = rel.target.Elem()
+		}
+	} else if rel.target.Kind() == reflect.Slice {
+		rel.target = rel.target.Elem()
+	} else {
+		return nil, false, errors.New(string(rel.kind) + " relationship " + field.Name + " of " + owner.Name() + " must be a slice")
+	}
+
+	if rel.target.Kind() != reflect.Struct {
+		return nil, false, errors.New("relationship " + field.Name + " of " + owner.Name() + " must hold structs")
+	}
+
+	if rel.foreignKey == "" || (rel.kind == manyToMany && (rel.join == "" || rel.reference == "")) {
+		return nil, false, errors.New("relationship " + field.Name + " of " + owner.Name() + " is missing fk, join or ref")
+	}
+
+	if rel.kind == belongsTo {
+		if _, ok := owner.FieldByName(rel.foreignKey); !ok {
+			return nil, false, errors.New("belongsTo relationship " + field.Name + " of " + owner.Name() + " refers to missing field " + rel.foreignKey)
+		}
+	}
+
+	return rel, true, nil
+}
+
+// Load eager loads the named relationships of the model pointed to by ptr, or of every model in the slice ptr points
+// to, with one query per relationship however many models there are
+func Load(ctx context.Context, app *app.App, ptr any, relations ...string) error {
+	value := reflect.ValueOf(ptr)
+	if value.Kind() != reflect.Pointer || value.IsNil() {
+		return errors.New("load requires a non-nil pointer to a struct or a slice of structs")
+	}
+
+	owners := value.Elem()
+	if owners.Kind() == reflect.Struct {
+		single := owners
+		owners = reflect.MakeSlice(reflect.SliceOf(single.Type()), 1, 1)
+		owners.Index(0).Set(single)
+		defer single.Set(owners.Index(0))
+	} else if owners.Kind() != reflect.Slice {
+		return errors.New("load requires a non-nil pointer to a struct or a slice of structs")
+	}
+
+	m, err := modelOf(owners.Type().Elem())
+	if err != nil {
+		return err
+	}
+
+	return loadRelations(ctx, app, m, owners, relations)
+}
+
+// loadRelations fills the named relationships of every element of owners, a slice of the model m
+func loadRelations(ctx context.Context, app *app.App, m *model, owners reflect.Value, relations []string) error {
+	for _, name := range relations {
+		rel, ok := m.relations[name]
+		if !ok {
+			return errors.New(m.table + " has no relationship named " + name)
+		}
+
+		if owners.Len() == 0 {
+			continue
+		}
+
+		var err error
+		switch rel.kind {
+		case belongsTo:
+			err = loadBelongsTo(ctx, app, owners, rel)
+		case hasMany:
+			err = loadHasMany(ctx, app, m, owners, rel)
+		case manyToMany:
+			err = loadManyToMany(ctx, app, m, owners, rel)
+		}
+		if err != nil {
+			return fmt.Errorf("error loading %s of %s: %w", name, m.table, err)
+		}
+	}
+
+	return nil
+}
+
+// loadBelongsTo loads the rows whose key is held by the foreign key field of each owner
+func loadBelongsTo(ctx context.Context, app *app.App, owners reflect.Value, rel *relation) error {
+	target, err := singleKeyModel(rel.target)
+	if err != nil {
+		return err
+	}
+
+	var keys []any
+	for i := 0; i < owners.Len(); i++ {
+		if key, ok := relationKey(owners.Index(i).FieldByName(rel.foreignKey)); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	related, err := selectRelated(ctx, app, rel.target, target, target.keyColumns[0], keys)
+	if err != nil {
+		return err
+	}
+
+	byKey := make(map[string]reflect.Value, related.Len())
+	for i := 0; i < related.Len(); i++ {
+		byKey[target.rowId(related.Index(i))] = related.Index(i)
+	}
+
+	for i := 0; i < owners.Len(); i++ {
+		key, ok := relationKey(owners.Index(i).FieldByName(rel.foreignKey))
+		if !ok {
+			continue
+		}
+
+		row, found := byKey[fmt.Sprint(key)]
+		if !found {
+			continue
+		}
+
+		field := owners.Index(i).Field(rel.field)
+		if field.Kind() == reflect.Pointer {
+			copied := reflect.New(rel.target)
+			copied.Elem().Set(row)
+			field.Set(copied)
+		} else {
+			field.Set(row)
+		}
+	}
+
+	return nil
+}
+
+// loadHasMany loads the rows whose foreign key column holds the key of each owner
+func loadHasMany(ctx context.Context, app *app.App, m *model, owners reflect.Value, rel *relation) error {
+	if len(m.keys) != 1 {
+		return errors.New("hasMany requires " + m.table + " to have a single column primary key")
+	}
+
+	target, err := modelOf(rel.target)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]any, owners.Len())
+	for i := range keys {
+		keys[i] = owners.Index(i).Field(m.keys[0]).Interface()
+	}
+
+	related, err := selectRelated(ctx, app, rel.target, target, rel.foreignKey, keys)
+	if err != nil {
+		return err
+	}
+
+	byOwner := make(map[string][]reflect.Value)
+	for i := 0; i < related.Len(); i++ {
+		if key, ok := relationKey(related.Index(i).FieldByName(rel.foreignKey)); ok {
+			byOwner[fmt.Sprint(key)] = append(byOwner[fmt.Sprint(key)], related.Index(i))
+		}
+	}
+
+	for i := 0; i < owners.Len(); i++ {
+		assignMany(owners.Index(i).Field(rel.field), byOwner[m.rowId(owners.Index(i))])
+	}
+
+	return nil
+}
+
+// loadManyToMany loads the rows linked to each owner through the join table
+func loadManyToMany(ctx context.Context, app *app.App, m *model, owners reflect.Value, rel *relation) error {
+	if len(m.keys) != 1 {
+		return errors.New("manyToMany requires " + m.table + " to have a single column primary key")
+	}
+
+	target, err := singleKeyModel(rel.target)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]any, owners.Len())
+	for i := range keys {
+		keys[i] = owners.Index(i).Field(m.keys[0]).Interface()
+	}
+
+	d := DialectOf(app)
+	condition, args := inCondition(d, d.QuoteIdentifier(rel.foreignKey), keys)
+	query := "SELECT " + d.QuoteIdentifier(rel.foreignKey) + ", " + d.QuoteIdentifier(rel.reference) + " FROM " + tableIn(ctx, app, rel.join) + " WHERE " + condition
+
+	queryCtx, cancel := WithQueryTimeout(ctx, app)
+	defer cancel()
+
+	rows, err := Reader(queryCtx, app).QueryContext(queryCtx, rebind(d, query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	links := make(map[string][]string)
+	var references []any
+	for rows.Next() {
+		var owner, reference any
+		err = rows.Scan(&owner, &reference)
+		if err != nil {
+			return err
+		}
+
+		links[scannedKey(owner)] = append(links[scannedKey(owner)], scannedKey(reference))
+		references = append(references, reference)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	related, err := selectRelated(ctx, app, rel.target, target, target.keyColumns[0], references)
+	if err != nil {
+		return err
+	}
+
+	byKey := make(map[string]reflect.Value, related.Len())
+	for i := 0; i < related.Len(); i++ {
+		byKey[target.rowId(related.Index(i))] = related.Index(i)
+	}
+
+	for i := 0; i < owners.Len(); i++ {
+		var rows []reflect.Value
+		for _, reference := range links[m.rowId(owners.Index(i))] {
+			if row, found := byKey[reference]; found {
+				rows = append(rows, row)
+			}
+		}
+		assignMany(owners.Index(i).Field(rel.field), rows)
+	}
+
+	return nil
+}
+
+// selectRelated returns a slice of typeOfStruct holding its rows whose column matches one of keys, soft deleted rows
+// are excluded
+func selectRelated(ctx context.Context, app *app.App, typeOfStruct reflect.Type, m *model, column string, keys []any) (reflect.Value, error) {
+	results := reflect.MakeSlice(reflect.SliceOf(typeOfStruct), 0, len(keys))
+	if len(keys) == 0 {
+		return results, nil
+	}
+
+	d := DialectOf(app)
+	condition, args := inCondition(d, d.QuoteIdentifier(column), keys)
+	if m.softDeletes() {
+		condition += " AND \"DeletedAt\" IS NULL"
+	}
+
+	query := "SELECT " + m.selectColumns(d) + " FROM " + m.qualifiedTable(ctx, app) + " WHERE " + condition
+
+	ctx, cancel := WithQueryTimeout(ctx, app)
+	defer cancel()
+
+	rows, err := Reader(ctx, app).QueryContext(ctx, rebind(d, query), args...)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := reflect.New(typeOfStruct).Elem()
+		err = rows.Scan(m.scanTargets(row)...)
+		if err != nil {
+			return results, err
+		}
+		results = reflect.Append(results, row)
+	}
+
+	return results, rows.Err()
+}
+
+// inCondition returns a condition matching column against any of keys, Postgres receives them as a single array
+// parameter so the statement is the same whatever the number of keys, other dialects get a placeholder per key
+func inCondition(d Dialect, column string, keys []any) (string, []any) {
+	if d.Name() == "postgres" {
+		return column + " = ANY(?)", []any{pq.Array(keys)}
+	}
+
+	placeholders := make([]string, len(keys))
+	for i := range placeholders {
+		placeholders[i] = "?"
+	}
+
+	return column + " IN (" + strings.Join(placeholders, ", ") + ")", keys
+}
+
+// singleKeyModel returns the mapping of the related model, which must have a single column primary key
+func singleKeyModel(typeOfStruct reflect.Type) (*model, error) {
+	m, err := modelOf(typeOfStruct)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.keys) != 1 {
+		return nil, errors.New(m.table + " must have a single column primary key to be related to")
+	}
+
+	return m, nil
+}
+
+// relationKey returns the key held by a foreign key field, ok is false for null values of sql.Null* fields
+func relationKey(field reflect.Value) (any, bool) {
+	if valuer, ok := field.Interface().(driver.Valuer); ok {
+		key, err := valuer.Value()
+		return key, err == nil && key != nil
+	}
+
+	return field.Interface(), true
+}
+
+// scannedKey formats a key scanned into an any the same way rowId formats keys of models
+func scannedKey(key any) string {
+	if bytes, ok := key.([]byte); ok {
+		return string(bytes)
+	}
+
+	return fmt.Sprint(key)
+}
+
+// assignMany sets a slice field to the given rows, an empty slice marks the relationship as loaded
+func assignMany(field reflect.Value, rows []reflect.Value) {
+	slice := reflect.MakeSlice(field.Type(), 0, len(rows))
+	field.Set(reflect.Append(slice, rows...))
+}
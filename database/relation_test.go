@@ -0,0 +1,147 @@
+package database_test
+
+import (
+	"GoWeb/database"
+	"GoWeb/testsupport"
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Author, Book, Label and AuthorLabel are test models covering every kind of relationship
+type Author struct {
+	Id   int64
+	Name string
+
+	Books  []Book  `db:"hasMany,fk=AuthorId"`
+	Labels []Label `db:"manyToMany,join=AuthorLabel,fk=AuthorId,ref=LabelId"`
+}
+
+type Book struct {
+	Id       int64
+	AuthorId int64
+	Title    string
+
+	Author *Author `db:"belongsTo,fk=AuthorId"`
+}
+
+type Label struct {
+	Id   int64
+	Name string
+}
+
+type AuthorLabel struct {
+	AuthorId  int64 `db:"pk"`
+	LabelId   int64 `db:"pk"`
+	CreatedAt time.Time
+}
+
+// library stores two authors, the first with two books and two labels and the second with neither
+func library(t *testing.T) (context.Context, *Author, *Author) {
+	t.Helper()
+
+	migrate(t,
+		Author{Id: 1, Name: "migrate"},
+		Book{Id: 1, AuthorId: 1, Title: "migrate"},
+		Label{Id: 1, Name: "migrate"},
+		AuthorLabel{AuthorId: 1, LabelId: 1, CreatedAt: time.Now()},
+	)
+	ctx, _ := testsupport.Tx(t)
+
+	prolific, idle := &Author{Name: "prolific"}, &Author{Name: "idle"}
+	insert(t, ctx, prolific, idle)
+	insert(t, ctx, &Book{AuthorId: prolific.Id, Title: "first"}, &Book{AuthorId: prolific.Id, Title: "second"})
+
+	fiction, poetry := &Label{Name: "fiction"}, &Label{Name: "poetry"}
+	insert(t, ctx, fiction, poetry)
+	insert(t, ctx, &AuthorLabel{AuthorId: prolific.Id, LabelId: fiction.Id}, &AuthorLabel{AuthorId: prolific.Id, LabelId: poetry.Id})
+
+	return ctx, prolific, idle
+}
+
+// insert stores every model pointed to by ptrs
+func insert(t *testing.T, ctx context.Context, ptrs ...any) {
+	t.Helper()
+
+	for _, ptr := range ptrs {
+		err := database.Insert(ctx, testsupport.App(t), ptr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWithLoadsBelongsTo(t *testing.T) {
+	ctx, prolific, _ := library(t)
+	app := testsupport.App(t)
+
+	books, err := database.From[Book](app).With("Author").All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(books) != 2 {
+		t.Fatalf("got %d books, want 2", len(books))
+	}
+	for _, book := range books {
+		if book.Author == nil || book.Author.Id != prolific.Id || book.Author.Name != prolific.Name {
+			t.Fatalf("book %q got author %+v, want %q", book.Title, book.Author, prolific.Name)
+		}
+	}
+}
+
+func TestWithLoadsHasManyAndManyToMany(t *testing.T) {
+	ctx, prolific, idle := library(t)
+	app := testsupport.App(t)
+
+	authors, err := database.From[Author](app).With("Books", "Labels").OrderBy("\"Id\"").All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(authors) != 2 || authors[0].Id != prolific.Id || authors[1].Id != idle.Id {
+		t.Fatalf("got %+v, want the prolific and the idle author", authors)
+	}
+
+	var titles, names []string
+	for _, book := range authors[0].Books {
+		titles = append(titles, book.Title)
+	}
+	for _, label := range authors[0].Labels {
+		names = append(names, label.Name)
+	}
+	slices.Sort(titles)
+	slices.Sort(names)
+
+	if !slices.Equal(titles, []string{"first", "second"}) || !slices.Equal(names, []string{"fiction", "poetry"}) {
+		t.Fatalf("got books %v and labels %v, want both books and both labels", titles, names)
+	}
+
+	if len(authors[1].Books) != 0 || len(authors[1].Labels) != 0 {
+		t.Fatalf("idle author got books %+v and labels %+v, want none", authors[1].Books, authors[1].Labels)
+	}
+}
+
+func TestLoadFillsSingleModel(t *testing.T) {
+	ctx, prolific, _ := library(t)
+	app := testsupport.App(t)
+
+	author, err := database.Find[Author](ctx, app, prolific.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.Load(ctx, app, &author, "Books")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(author.Books) != 2 {
+		t.Fatalf("got %d books, want 2", len(author.Books))
+	}
+
+	err = database.Load(ctx, app, &author, "Publisher")
+	if err == nil {
+		t.Fatal("loading an unknown relationship succeeded")
+	}
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Executor is satisfied by *sql.DB and *sql.Tx, models run their queries through one so that they do not need to
+// know whether they are talking to the primary, a replica or an open transaction
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type txContextKey struct{}
+type primaryContextKey struct{}
+
+var (
+	replicaHealth sync.Map      // Maps each replica *sql.DB to an *atomic.Bool reporting whether it passed its last check
+	replicaCursor atomic.Uint64 // Round-robin position shared by all reads
+)
+
+const replicaPingTimeout = time.Second
+
+// ConnectReplicas opens a connection to every configured read replica, replicas that can't be reached are kept
+// but marked unhealthy so that reads fall back to the primary until a health check succeeds
+func ConnectReplicas(app *app.App) []*sql.DB {
+	var replicas []*sql.DB
+	for i, dsn := range app.Config.Db.Replicas {
+		db, err := openInstrumented(app, driverName(app), dsn)
+		if err != nil {
+			slog.Error("error opening read replica connection", "replica", i, "error", err)
+			continue
+		}
+
+		replicas = append(replicas, db)
+		if setReplicaHealth(db, pingReplica(db) == nil) {
+			slog.Info("connected to read replica successfully", "replica", i)
+		} else {
+			slog.Warn("read replica is unreachable, reads will use the primary until it recovers", "replica", i)
+		}
+	}
+
+	return replicas
+}
+
+// ScheduledReplicaHealthCheck pings every read replica and updates which of them may receive reads
+func ScheduledReplicaHealthCheck(app *app.App) {
+	for i, db := range app.Replicas {
+		err := pingReplica(db)
+		wasHealthy := replicaHealthy(db)
+		setReplicaHealth(db, err == nil)
+
+		if wasHealthy && err != nil {
+			slog.Warn("read replica failed health check, routing reads elsewhere", "replica", i, "error", err)
+		} else if !wasHealthy && err == nil {
+			slog.Info("read replica recovered, routing reads to it again", "replica", i)
+		}
+	}
+}
+
+// Writer returns the executor that writes should use, the open transaction if ctx carries one, otherwise the primary
+func Writer(ctx context.Context, app *app.App) Executor {
+	if tx, ok := ctx.Value(txContextKey{}).(*sql.Tx); ok {
+		return tx
+	}
+
+	return app.Db
+}
+
+// Reader returns the executor that reads should use, reads inside a transaction or marked with WithPrimary stay on
+// the primary, all other reads are spread round-robin across healthy replicas falling back to the primary
+func Reader(ctx context.Context, app *app.App) Executor {
+	if tx, ok := ctx.Value(txContextKey{}).(*sql.Tx); ok {
+		return tx
+	}
+
+	if pinned, _ := ctx.Value(primaryContextKey{}).(bool); pinned || len(app.Replicas) == 0 {
+		return app.Db
+	}
+
+	for range app.Replicas {
+		db := app.Replicas[replicaCursor.Add(1)%uint64(len(app.Replicas))]
+		if replicaHealthy(db) {
+			return db
+		}
+	}
+
+	return app.Db
+}
+
+// WithPrimary returns a context whose reads are pinned to the primary, used when a read must observe a write that
+// was just made and can't tolerate replication lag
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey{}, true)
+}
+
+// WithTransaction runs fn inside a transaction on the primary, the transaction is carried by the context given to fn
+// so every Reader and Writer call made with it joins the transaction. Calls made while a transaction is already
+// open reuse it, the transaction is committed if fn returns nil and rolled back otherwise
+func WithTransaction(ctx context.Context, app *app.App, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txContextKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := app.Db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("error beginning transaction: " + err.Error())
+		return err
+	}
+
+	err = fn(ContextWithTx(ctx, tx))
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			slog.Error("error rolling back transaction: " + rollbackErr.Error())
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ContextWithTx returns a context that carries tx, Reader and Writer calls made with it run inside the transaction.
+// Most code should use WithTransaction instead, this is for callers that manage the transaction themselves
+func ContextWithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txContextKey{}, tx)
+}
+
+// pingReplica pings a replica with a short timeout so an unresponsive host can't stall the health checks
+func pingReplica(db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaPingTimeout)
+	defer cancel()
+
+	return db.PingContext(ctx)
+}
+
+// replicaHealthy reports whether the replica passed its last health check
+func replicaHealthy(db *sql.DB) bool {
+	healthy, ok := replicaHealth.Load(db)
+	return ok && healthy.(*atomic.Bool).Load()
+}
+
+// setReplicaHealth records the result of a health check and returns it
+func setReplicaHealth(db *sql.DB, healthy bool) bool {
+	state, _ := replicaHealth.LoadOrStore(db, &atomic.Bool{})
+	state.(*atomic.Bool).Store(healthy)
+	return healthy
+}
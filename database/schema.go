@@ -0,0 +1,94 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/lib/pq"
+)
+
+type tenantContextKey struct{}
+
+// tenantNamePattern limits tenant names to characters that are safe in schema names and subdomains
+var tenantNamePattern = regexp.MustCompile("^[a-z0-9_]{1,50}$")
+
+// ErrUnknownTenant is returned when a tenant isn't one of the configured tenants
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// Schema returns the schema that queries made with ctx run against, the schema of the tenant carried by ctx in
+// multi-tenant mode, otherwise the configured schema which defaults to public
+func Schema(ctx context.Context, app *app.App) string {
+	if tenant, ok := ctx.Value(tenantContextKey{}).(string); ok {
+		return TenantSchema(tenant)
+	}
+
+	if app.Config.Db.Schema == "" {
+		return "public"
+	}
+
+	return app.Config.Db.Schema
+}
+
+// TenantSchema returns the name of the schema holding the tables of a tenant
+func TenantSchema(tenant string) string {
+	return "tenant_" + tenant
+}
+
+// WithTenant returns a context whose queries run against the schema of the tenant, the tenant must be configured
+func WithTenant(ctx context.Context, app *app.App, tenant string) (context.Context, error) {
+	if !IsTenant(app, tenant) {
+		return ctx, ErrUnknownTenant
+	}
+
+	return context.WithValue(ctx, tenantContextKey{}, tenant), nil
+}
+
+// Tenant returns the tenant carried by ctx, if any
+func Tenant(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(string)
+	return tenant, ok
+}
+
+// IsTenant reports whether the name belongs to a configured tenant
+func IsTenant(app *app.App, tenant string) bool {
+	if !tenantNamePattern.MatchString(tenant) {
+		return false
+	}
+
+	for _, configured := range app.Config.Tenancy.Tenants {
+		if configured == tenant {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EachTenant calls fn once for every configured tenant with a context scoped to that tenant, when multi-tenant mode
+// is disabled fn is called once with ctx. Background work such as scheduled tasks uses it to reach every schema
+func EachTenant(ctx context.Context, app *app.App, fn func(ctx context.Context) error) error {
+	if !app.Config.Tenancy.Enabled {
+		return fn(ctx)
+	}
+
+	var errs []error
+	for _, tenant := range app.Config.Tenancy.Tenants {
+		tenantCtx, err := WithTenant(ctx, app, tenant)
+		if err == nil {
+			err = fn(tenantCtx)
+		}
+
+		if err != nil {
+			errs = append(errs, errors.New("tenant "+tenant+": "+err.Error()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// qualifiedTable returns the quoted, schema qualified name of a table
+func qualifiedTable(schema string, table string) string {
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(table)
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// SearchResult is a row matching a full-text search together with its relevance and a highlighted snippet of every
+// searchable field, matched words in the snippets are wrapped in <mark> and the rest of the text is HTML escaped
+type SearchResult[T any] struct {
+	Item     T
+	Rank     float64
+	Snippets map[string]template.HTML
+}
+
+const searchColumn = "SearchVector"
+
+// Markers ts_headline wraps matches in, they are swapped for <mark> tags after the snippet has been escaped
+const (
+	highlightStart = "GOWEBHIGHLIGHTSTART"
+	highlightStop  = "GOWEBHIGHLIGHTSTOP"
+)
+
+// Search returns the rows matching terms ranked by relevance, terms use web search syntax: quoted phrases, "or" and
+// -excluded words. The model must have fields tagged search:"<weight>", other conditions, Limit and Offset of the
+// query still apply while its OrderBy is replaced by the ranking
+func (q *Query[T]) Search(ctx context.Context, terms string) ([]SearchResult[T], error) {
+	m, err := q.model()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.searchable) == 0 {
+		return nil, errors.New(m.table + " has no fields tagged as searchable")
+	}
+
+	if !isPostgres(q.app) {
+		return nil, unsupported(q.app, "full-text search")
+	}
+
+	language := pq.QuoteLiteral(searchLanguage(q.app)) + "::regconfig"
+	options := pq.QuoteLiteral("StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MaxFragments=2")
+
+	columns := m.selectColumns(DialectOf(q.app)) + ", ts_rank(" + pq.QuoteIdentifier(searchColumn) + ", search_query) AS search_rank"
+	for _, field := range m.searchable {
+		columns += ", ts_headline(" + language + ", coalesce(" + pq.QuoteIdentifier(field.column) + ", ''), search_query, " + options + ")"
+	}
+
+	where := q.where(m)
+	if where == "" {
+		where = " WHERE "
+	} else {
+		where += " AND "
+	}
+	where += pq.QuoteIdentifier(searchColumn) + " @@ search_query"
+
+	query := "SELECT " + columns + " FROM " + m.qualifiedTable(ctx, q.app) + ", websearch_to_tsquery(" + language + ", ?) AS search_query" + where + " ORDER BY search_rank DESC"
+	if q.limit > 0 {
+		query += " LIMIT " + strconv.Itoa(q.limit)
+	}
+	if q.offset > 0 {
+		query += " OFFSET " + strconv.Itoa(q.offset)
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx, q.app)
+	defer cancel()
+
+	args := append([]any{terms}, q.args...)
+	rows, err := Reader(ctx, q.app).QueryContext(ctx, rebind(DialectOf(q.app), query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult[T]
+	for rows.Next() {
+		var result SearchResult[T]
+		snippets := make([]string, len(m.searchable))
+
+		targets := m.scanTargets(reflect.ValueOf(&result.Item).Elem())
+		targets = append(targets, &result.Rank)
+		for i := range snippets {
+			targets = append(targets, &snippets[i])
+		}
+
+		err = rows.Scan(targets...)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Snippets = make(map[string]template.HTML, len(snippets))
+		for i, field := range m.searchable {
+			result.Snippets[field.column] = highlight(snippets[i])
+		}
+
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// createSearchColumn adds a generated tsvector column combining the searchable fields and a GIN index over it. The
+// column is only created once, after changing the searchable fields or the language drop it to have it rebuilt
+func createSearchColumn(app *app.App, tableName string, fields []searchField) error {
+	if !isPostgres(app) {
+		return unsupported(app, "full-text search")
+	}
+
+	schema := Schema(context.Background(), app)
+
+	var columnExists bool
+	err := app.Db.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2 AND column_name = $3)", schema, tableName, searchColumn).Scan(&columnExists)
+	if err != nil {
+		slog.Error("error checking if search column exists in table: " + tableName)
+		return err
+	}
+
+	if columnExists {
+		slog.Info("search column already exists in table: " + tableName)
+		return nil
+	}
+
+	language := pq.QuoteLiteral(searchLanguage(app)) + "::regconfig"
+	vectors := make([]string, len(fields))
+	for i, field := range fields {
+		vectors[i] = fmt.Sprintf("setweight(to_tsvector(%s, coalesce(%s, '')), '%s')", language, pq.QuoteIdentifier(field.column), field.weight)
+	}
+
+	sanitizedTableName := qualifiedTable(schema, tableName)
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s tsvector GENERATED ALWAYS AS (%s) STORED", sanitizedTableName, pq.QuoteIdentifier(searchColumn), strings.Join(vectors, " || "))
+	_, err = app.Db.Exec(query)
+	if err != nil {
+		slog.Error("error creating search column in table: " + tableName)
+		return err
+	}
+
+	query = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (%s)", pq.QuoteIdentifier(tableName+"_"+searchColumn+"_idx"), sanitizedTableName, pq.QuoteIdentifier(searchColumn))
+	_, err = app.Db.Exec(query)
+	if err != nil {
+		slog.Error("error creating search index on table: " + tableName)
+		return err
+	}
+
+	slog.Info("search column created successfully in table: " + tableName)
+	return nil
+}
+
+// searchLanguage returns the configured text search configuration
+func searchLanguage(app *app.App) string {
+	if app.Config.Db.SearchLanguage == "" {
+		return "english"
+	}
+
+	return app.Config.Db.SearchLanguage
+}
+
+// highlight escapes a ts_headline snippet and swaps its match markers for <mark> tags
+func highlight(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, highlightStop, "</mark>")
+	return template.HTML(escaped)
+}
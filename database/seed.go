@@ -0,0 +1,190 @@
+package database
+
+import (
+	"GoWeb/app"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// seeder is a named function that populates the database
+type seeder struct {
+	name string
+	run  func(ctx context.Context, app *app.App) error
+}
+
+var seeders []seeder
+
+// ErrSeedingNotAllowed is returned when seeders are run outside a development or testing environment
+var ErrSeedingNotAllowed = errors.New("seeding is only allowed when Environment is set and is not production")
+
+// RegisterSeeder registers a function that populates the database, seeders run in the order they were registered
+// and should be written so that running them again does not duplicate data
+func RegisterSeeder(name string, run func(ctx context.Context, app *app.App) error) {
+	seeders = append(seeders, seeder{name: name, run: run})
+}
+
+// RegisterFixture registers a seeder that reads a JSON array of T from the file at path in fsys and inserts every
+// element that is missing by primary key, each element must have its key set so that re-running the seeder leaves
+// existing rows, and any changes made to them since, alone
+func RegisterFixture[T any](name string, fsys fs.FS, path string) {
+	RegisterSeeder(name, func(ctx context.Context, app *app.App) error {
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return fmt.Errorf("error reading fixture file %s: %w", path, err)
+		}
+
+		var rows []T
+		err = json.Unmarshal(content, &rows)
+		if err != nil {
+			return fmt.Errorf("error decoding fixture file %s: %w", path, err)
+		}
+
+		for i := range rows {
+			err = Upsert(ctx, app, &rows[i])
+			if err != nil {
+				return fmt.Errorf("error loading fixture %s row %d: %w", path, i, err)
+			}
+		}
+
+		var dummy T
+		return syncIdSequence(ctx, app, reflect.TypeOf(dummy))
+	})
+}
+
+// RunSeeders runs every registered seeder, or only the named ones, inside a single transaction so a failing seeder
+// leaves the database untouched. It refuses to run unless the configured environment is set and isn't production
+func RunSeeders(ctx context.Context, app *app.App, names ...string) error {
+	environment := strings.ToLower(app.Config.Environment)
+	if environment == "" || environment == "production" {
+		return ErrSeedingNotAllowed
+	}
+
+	selected := seeders
+	if len(names) > 0 {
+		selected = nil
+		for _, name := range names {
+			found := false
+			for _, s := range seeders {
+				if s.name == name {
+					selected = append(selected, s)
+					found = true
+				}
+			}
+
+			if !found {
+				return errors.New("unknown seeder: " + name)
+			}
+		}
+	}
+
+	return WithTransaction(ctx, app, func(ctx context.Context) error {
+		for _, s := range selected {
+			err := s.run(ctx, app)
+			if err != nil {
+				slog.Error("error running seeder: " + s.name)
+				return err
+			}
+
+			slog.Info("seeder ran successfully: " + s.name)
+		}
+
+		return nil
+	})
+}
+
+// Upsert inserts the model pointed to by ptr, or if a row with the same primary key already exists updates only the
+// named columns of it and leaves it untouched when none are named. Zero CreatedAt and UpdatedAt fields are set to the
+// current time and the Version of an updated row is incremented
+func Upsert(ctx context.Context, app *app.App, ptr any, columns ...string) error {
+	value, m, err := modelValue(ptr)
+	if err != nil {
+		return err
+	}
+
+	if !m.hasKey() {
+		return errors.New("upsert requires " + m.table + " to have a primary key")
+	}
+
+	for _, key := range m.keys {
+		if value.Field(key).IsZero() {
+			return errors.New("upsert requires the primary key of " + m.table + " to be set")
+		}
+	}
+
+	now := time.Now()
+	if m.createdAt >= 0 && value.Field(m.createdAt).IsZero() {
+		value.Field(m.createdAt).Set(reflect.ValueOf(now))
+	}
+	if m.updatedAt >= 0 && value.Field(m.updatedAt).IsZero() {
+		value.Field(m.updatedAt).Set(reflect.ValueOf(now))
+	}
+
+	if m.version >= 0 && value.Field(m.version).IsZero() {
+		value.Field(m.version).SetInt(1)
+	}
+
+	var updates, increments []string
+	for _, column := range columns {
+		if !slices.Contains(m.columns, column) || column == "Version" {
+			return errors.New("upsert cannot update column " + column + " of " + m.table)
+		}
+
+		updates = append(updates, column)
+	}
+	if len(updates) > 0 && m.version >= 0 {
+		increments = append(increments, "Version")
+	}
+
+	inserted := append(append([]string(nil), m.keyColumns...), m.columns...)
+	args := append(m.keyValues(value), m.values(value)...)
+
+	d := DialectOf(app)
+	quoted := make([]string, len(inserted))
+	placeholders := make([]string, len(inserted))
+	for i, column := range inserted {
+		quoted[i] = d.QuoteIdentifier(column)
+		placeholders[i] = "?"
+	}
+
+	table := m.qualifiedTable(ctx, app)
+	query := "INSERT INTO " + table + " (" + strings.Join(quoted, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")" + d.Upsert(table, m.keyColumns, updates, increments)
+
+	ctx, cancel := WithQueryTimeout(ctx, app)
+	defer cancel()
+
+	_, err = Writer(ctx, app).ExecContext(ctx, rebind(d, query), args...)
+	return err
+}
+
+// syncIdSequence moves the sequence behind an auto-incrementing primary key past the largest stored key, rows inserted
+// with explicit keys don't advance it and later inserts would otherwise collide with them. Dialects advancing it by
+// themselves and models with other keys are skipped
+func syncIdSequence(ctx context.Context, app *app.App, typeOfStruct reflect.Type) error {
+	m, err := modelOf(typeOfStruct)
+	if err != nil {
+		return err
+	}
+
+	if !m.autoIncrements() {
+		return nil
+	}
+
+	query := DialectOf(app).ResetSequence(m.qualifiedTable(ctx, app), m.keyColumns[0])
+	if query == "" {
+		return nil
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx, app)
+	defer cancel()
+
+	_, err = Writer(ctx, app).ExecContext(ctx, query)
+	return err
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// NewUUIDv4 returns a random RFC 9562 version 4 UUID in its canonical text form
+func NewUUIDv4() (string, error) {
+	var uuid [16]byte
+	_, err := rand.Read(uuid[:])
+	if err != nil {
+		return "", err
+	}
+
+	return formatUUID(uuid, 4), nil
+}
+
+// NewUUIDv7 returns an RFC 9562 version 7 UUID in its canonical text form, it starts with the current Unix time in
+// milliseconds so ids created later sort after earlier ones
+func NewUUIDv7() (string, error) {
+	var uuid [16]byte
+	_, err := rand.Read(uuid[6:])
+	if err != nil {
+		return "", err
+	}
+
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], uint64(time.Now().UnixMilli()))
+	copy(uuid[:6], timestamp[2:])
+
+	return formatUUID(uuid, 7), nil
+}
+
+// formatUUID sets the version and RFC 9562 variant bits of uuid and formats it as 8-4-4-4-12 hex digits
+func formatUUID(uuid [16]byte, version byte) string {
+	uuid[6] = uuid[6]&0x0f | version<<4
+	uuid[8] = uuid[8]&0x3f | 0x80
+
+	encoded := hex.EncodeToString(uuid[:])
+	return encoded[:8] + "-" + encoded[8:12] + "-" + encoded[12:16] + "-" + encoded[16:20] + "-" + encoded[20:]
+}
@@ -0,0 +1,90 @@
+package mail
+
+import (
+	"GoWeb/config"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email, set app.Mailer to a custom implementation to send through another provider
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// LogMailer writes messages to the log instead of sending them, for development
+type LogMailer struct{}
+
+// SMTPMailer sends messages through an SMTP server, authenticating with PLAIN auth when a username is configured
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// FromConfig returns the mailer selected by MailDriver, "smtp" or "log" which is the default
+func FromConfig(c config.Configuration) (Mailer, error) {
+	switch c.Mail.Driver {
+	case "", "log":
+		return LogMailer{}, nil
+	case "smtp":
+		if c.Mail.Host == "" || c.Mail.From == "" {
+			return nil, errors.New("smtp mailer requires MailHost and MailFrom")
+		}
+
+		return SMTPMailer{Host: c.Mail.Host, Port: c.Mail.Port, Username: c.Mail.Username, Password: c.Mail.Password, From: c.Mail.From}, nil
+	}
+
+	return nil, errors.New("unknown MailDriver: " + c.Mail.Driver)
+}
+
+func (LogMailer) Send(_ context.Context, message Message) error {
+	slog.Info("email not sent, logged instead", "to", message.To, "subject", message.Subject, "body", message.Body)
+	return nil
+}
+
+func (m SMTPMailer) Send(ctx context.Context, message Message) error {
+	if strings.ContainsAny(message.To+message.Subject, "\r\n") {
+		return errors.New("recipient and subject can't contain line breaks")
+	}
+
+	port := m.Port
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		m.From, message.To, message.Subject, time.Now().Format(time.RFC1123Z), strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+	// smtp.SendMail can't be cancelled, run it aside so a slow server doesn't outlive the request
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(m.Host, port), auth, m.From, []string{message.To}, []byte(body))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"GoWeb/app"
 	"GoWeb/config"
 	"GoWeb/database"
+	"GoWeb/mail"
+	"GoWeb/middleware"
 	"GoWeb/models"
 	"GoWeb/routes"
 	"GoWeb/templating"
 	"context"
 	"embed"
 	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
@@ -45,23 +49,98 @@ func main() {
 		panic("error creating log file: " + err.Error())
 	}
 
-	logger := slog.New(slog.NewTextHandler(file, nil))
+	var logLevel slog.Level
+	if appLoaded.Config.LogLevel != "" {
+		err = logLevel.UnmarshalText([]byte(appLoaded.Config.LogLevel))
+		if err != nil {
+			panic("invalid LogLevel in config: " + err.Error())
+		}
+	}
+
+	logger := slog.New(slog.NewTextHandler(file, &slog.HandlerOptions{Level: logLevel}))
 	slog.SetDefault(logger) // Set structured logger globally
 
+	// Set up email delivery
+	appLoaded.Mailer, err = mail.FromConfig(appLoaded.Config)
+	if err != nil {
+		slog.Error("error configuring mailer: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Prepare the keys of encrypted model fields
+	err = database.LoadEncryptionKeys(&appLoaded)
+	if err != nil {
+		slog.Error("error loading encryption keys: " + err.Error())
+		os.Exit(1)
+	}
+
 	// Connect to database and run migrations
 	appLoaded.Db = database.Connect(&appLoaded)
+	appLoaded.Replicas = database.ConnectReplicas(&appLoaded)
 	if appLoaded.Config.Db.AutoMigrate {
-		err = models.RunAllMigrations(&appLoaded)
+		err = database.WithMigrationLock(&appLoaded, func() error {
+			if appLoaded.Config.Tenancy.Enabled {
+				return models.RunAllTenantMigrations(&appLoaded)
+			}
+			return models.RunAllMigrations(&appLoaded)
+		})
 		if err != nil {
 			slog.Error("error running migrations: " + err.Error())
 			os.Exit(1)
 		}
 	}
 
+	// Run seeders in every tenant schema instead of starting the server when started with the seed subcommand, e.g. "go run . seed users"
+	if flag.Arg(0) == "seed" {
+		models.RegisterAllSeeders()
+		err = database.EachTenant(context.Background(), &appLoaded, func(ctx context.Context) error {
+			return database.RunSeeders(ctx, &appLoaded, flag.Args()[1:]...)
+		})
+		if err != nil {
+			slog.Error("error running seeders: " + err.Error())
+			fmt.Println("error running seeders: " + err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Println("database seeded successfully")
+		return
+	}
+
+	// Encrypt encrypted fields again with the current key instead of starting the server, e.g. "go run . reencrypt"
+	if flag.Arg(0) == "reencrypt" {
+		err = database.EachTenant(context.Background(), &appLoaded, func(ctx context.Context) error {
+			return models.ReEncryptAll(ctx, &appLoaded)
+		})
+		if err != nil {
+			slog.Error("error re-encrypting fields: " + err.Error())
+			fmt.Println("error re-encrypting fields: " + err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Println("fields re-encrypted successfully")
+		return
+	}
+
+	// Turn off two-factor authentication of a user who lost their device instead of starting the server, e.g.
+	// "go run . reset-2fa john", in multi-tenant mode name the tenant after the username
+	if flag.Arg(0) == "reset-2fa" {
+		err = models.ResetTwoFactorByUsername(context.Background(), &appLoaded, flag.Arg(1), flag.Arg(2))
+		if err != nil {
+			slog.Error("error resetting two-factor authentication: " + err.Error())
+			fmt.Println("error resetting two-factor authentication: " + err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Println("two-factor authentication reset successfully")
+		return
+	}
+
 	// Assign and run scheduled tasks
 	appLoaded.ScheduledTasks = app.Scheduled{
 		EveryReboot: []func(app *app.App){models.ScheduledSessionCleanup},
-		EveryMinute: []func(app *app.App){models.ScheduledSessionCleanup},
+		EverySecond: []func(app *app.App){database.ScheduledReplicaHealthCheck},
+		EveryMinute: []func(app *app.App){models.ScheduledSessionCleanup, models.ScheduledUserTokenCleanup, models.ScheduledPendingAuthCleanup, models.ScheduledHealthReport},
+		EveryHour:   []func(app *app.App){models.ScheduledLoginAttemptCleanup},
 	}
 
 	// Define Routes
@@ -75,13 +154,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Probes are served before the tenant middleware so load balancers don't have to name a tenant
+	handler := http.NewServeMux()
+	routes.Probes(&appLoaded, handler)
+	handler.HandleFunc("/", middleware.QueryCount(&appLoaded, middleware.Tenant(&appLoaded, middleware.AuditActor(&appLoaded, http.DefaultServeMux.ServeHTTP))))
+
 	// Start server
-	server := &http.Server{Addr: appLoaded.Config.Listen.Ip + ":" + appLoaded.Config.Listen.Port}
+	server := &http.Server{
+		Addr:    appLoaded.Config.Listen.Ip + ":" + appLoaded.Config.Listen.Port,
+		Handler: handler,
+	}
 	go func() {
 		slog.Info("starting server and listening on " + appLoaded.Config.Listen.Ip + ":" + appLoaded.Config.Listen.Port)
 		err := server.ListenAndServe()
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			slog.Error("could not listen on %s: %v\n", appLoaded.Config.Listen.Ip+":"+appLoaded.Config.Listen.Port, err)
+			slog.Error("could not listen on " + appLoaded.Config.Listen.Ip + ":" + appLoaded.Config.Listen.Port + ": " + err.Error())
 			os.Exit(1)
 		}
 	}()
@@ -97,7 +184,12 @@ func main() {
 
 	err = server.Shutdown(context.Background())
 	if err != nil {
-		slog.Error("could not gracefully shutdown the server: %v\n", err)
+		slog.Error("could not gracefully shutdown the server: " + err.Error())
 		os.Exit(1)
 	}
+
+	err = database.ClosePubSub(&appLoaded)
+	if err != nil {
+		slog.Error("could not close pub-sub listener: " + err.Error())
+	}
 }
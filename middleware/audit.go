@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"GoWeb/models"
+	"net/http"
+)
+
+// AuditActor attributes the audited changes made while handling a request to the logged-in user, the user is only
+// looked up once the request makes its first audited change
+func AuditActor(app *app.App, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := database.WithActorFunc(r.Context(), func() (int64, bool) {
+			user, err := models.CurrentUser(app, r)
+			return user.Id, err == nil
+		})
+
+		f(w, r.WithContext(ctx))
+	}
+}
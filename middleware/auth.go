@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"GoWeb/app"
+	"GoWeb/models"
+	"log/slog"
+	"net/http"
+)
+
+// Auth only lets logged-in users through, everyone else is redirected to the login page
+func Auth(app *app.App, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := loggedIn(app, r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		f(w, r)
+	}
+}
+
+// Verified only lets logged-in users who have confirmed their email address through, use it in place of Auth on
+// routes unverified accounts shouldn't reach. Users who haven't confirmed their address are sent to the page asking
+// them to
+func Verified(app *app.App, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := loggedIn(app, r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		if !user.Verified() {
+			http.Redirect(w, r, "/verify-email", http.StatusFound)
+			return
+		}
+
+		f(w, r)
+	}
+}
+
+// Role only lets logged-in users with the named role through, others get a 403
+func Role(app *app.App, role string, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return authorize(app, func(user models.User) bool { return user.HasRole(role) }, f)
+}
+
+// Permission only lets logged-in users with a role granting the named permission through, others get a 403
+func Permission(app *app.App, permission string, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return authorize(app, func(user models.User) bool { return models.Can(user, permission) }, f)
+}
+
+// authorize loads the roles of the logged-in user and only lets them through if allowed accepts them, logged-out
+// users are redirected to the login page
+func authorize(app *app.App, allowed func(user models.User) bool, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := loggedIn(app, r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		err = user.LoadAccess(r.Context(), app)
+		if err != nil {
+			slog.Error("error loading roles of user: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if !allowed(user) {
+			slog.Info("access denied", "user", user.Id, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		f(w, r)
+	}
+}
+
+// loggedIn finds the logged-in user of the request and records that their session was used, the one place doing so
+// keeps it to a single write per request
+func loggedIn(app *app.App, r *http.Request) (models.User, error) {
+	session, err := models.CurrentSession(app, r)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	models.TouchSession(r.Context(), app, session, models.ClientOf(app, r))
+	return models.UserById(r.Context(), app, session.UserId)
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"log/slog"
+	"net/http"
+)
+
+// QueryCount counts the database queries run while handling each request and logs the total, requests going over the
+// configured maximum are logged as warnings since they usually point to N+1 query patterns
+func QueryCount(app *app.App, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := database.CountQueries(r.Context())
+		f(w, r.WithContext(ctx))
+
+		count := database.QueryCount(ctx)
+		limit := int64(app.Config.Db.MaxQueriesPerRequest)
+		if limit > 0 && count > limit {
+			slog.Warn("request ran more queries than allowed, check for N+1 queries", "method", r.Method, "path", r.URL.Path, "queries", count, "limit", limit)
+		} else {
+			slog.Debug("request finished", "method", r.Method, "path", r.URL.Path, "queries", count)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Tenant routes each request to the schema of the tenant it names, either by the first label of the host name or by
+// a header depending on the configured mode. Requests naming an unknown tenant are rejected. It does nothing when
+// multi-tenant mode is disabled
+func Tenant(app *app.App, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.Config.Tenancy.Enabled {
+			f(w, r)
+			return
+		}
+
+		var tenant string
+		if app.Config.Tenancy.Mode == "header" {
+			header := app.Config.Tenancy.Header
+			if header == "" {
+				header = "X-Tenant"
+			}
+			tenant = r.Header.Get(header)
+		} else {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			tenant, _, _ = strings.Cut(host, ".")
+		}
+
+		ctx, err := database.WithTenant(r.Context(), app, strings.ToLower(tenant))
+		if err != nil {
+			slog.Info("request for unknown tenant: " + tenant)
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+
+		f(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"context"
+)
+
+// ReEncryptAll encrypts the encrypted fields of every model again with the current encryption key, run it after
+// rotating keys and before removing the old key from the configuration
+func ReEncryptAll(ctx context.Context, app *app.App) error {
+	for _, dummy := range migrationDummies() {
+		_, err := database.ReEncrypt(ctx, app, dummy)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,16 @@
+package models
+
+import "testing"
+
+// CountHashCompares counts the bcrypt comparisons made by password checks until the test ends
+func CountHashCompares(t testing.TB) *int {
+	count := new(int)
+	original := compareHash
+	compareHash = func(hash []byte, password []byte) error {
+		*count++
+		return original(hash, password)
+	}
+	t.Cleanup(func() { compareHash = original })
+
+	return count
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"context"
+	"log/slog"
+)
+
+// DatabaseHealth probes the database connections and checks that every migration has been applied
+func DatabaseHealth(ctx context.Context, app *app.App) database.HealthReport {
+	return database.Health(ctx, app, migrationDummies()...)
+}
+
+// DatabaseReachable reports whether the primary database answers, for the public readiness probe
+func DatabaseReachable(ctx context.Context, app *app.App) bool {
+	return database.Reachable(ctx, app)
+}
+
+// ScheduledHealthReport logs the database health and connection pool statistics, problems are logged as warnings
+func ScheduledHealthReport(app *app.App) {
+	report := DatabaseHealth(context.Background(), app)
+
+	stats := report.Primary.Stats
+	attrs := []any{
+		"latencyMs", report.Primary.LatencyMs,
+		"open", stats.OpenConnections,
+		"inUse", stats.InUse,
+		"idle", stats.Idle,
+		"waitCount", stats.WaitCount,
+		"waitDuration", stats.WaitDuration,
+	}
+
+	if !report.Healthy {
+		attrs = append(attrs, "error", report.Primary.Error, "pendingMigrations", report.PendingMigrations, "migrationError", report.MigrationError)
+		slog.Warn("database is unhealthy", attrs...)
+	} else {
+		slog.Debug("database is healthy", attrs...)
+	}
+
+	for _, replica := range report.Replicas {
+		if !replica.Reachable {
+			slog.Warn("read replica is unreachable", "replica", replica.Index, "error", replica.Error)
+		} else if replica.LagSeconds != nil {
+			slog.Debug("read replica lag", "replica", replica.Index, "lagSeconds", *replica.LagSeconds)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package models
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the login throttling configuration
+const (
+	defaultLoginMaxAttempts      = 5
+	defaultLoginMaxAttemptsPerIp = 20
+	defaultLoginAttemptWindow    = 15 * time.Minute
+	defaultLoginLockoutDuration  = 15 * time.Minute
+
+	loginDelayStep = 250 * time.Millisecond // Delay after the first failure, it doubles with every further failure
+	loginDelayMax  = 5 * time.Second
+)
+
+var (
+	// ErrInvalidCredentials is returned for a wrong password and an unknown username alike, so login responses don't
+	// reveal which usernames exist
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrTooManyLoginAttempts is returned while the username or IP address is locked out after repeated failed logins,
+	// unknown usernames are locked out the same way as existing ones
+	ErrTooManyLoginAttempts = errors.New("too many failed login attempts, try again later")
+)
+
+// LoginAttempt records a password check, failed attempts of a username or from an IP address lead to delays and
+// lockouts. Usernames are stored lowercased whether or not a user has them
+type LoginAttempt struct {
+	Id        int64
+	Username  string `index:""`
+	Ip        string `index:""`
+	Succeeded bool
+	CreatedAt time.Time
+}
+
+// dummyPasswordHash is compared against when the username doesn't exist, so unknown usernames take as long to reject
+// as wrong passwords
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := hashPassword("dummy password")
+	if err != nil {
+		return "$2a$10$" + strings.Repeat("x", 53) // Still runs a full bcrypt comparison before failing
+	}
+
+	return hash
+})
+
+// loginThrottle is what the recent failed logins of a username and IP address allow
+type loginThrottle struct {
+	usernameFailures int  // Recent failed logins of the username
+	ipFailures       int  // Recent failed logins from the IP address
+	locked           bool // Logins are refused until the lockout ends
+}
+
+// delay returns how long to wait before checking the password, doubling with every recent failure. Failures from the
+// IP address count proportionally less since it is allowed more of them
+func (t loginThrottle) delay(app *app.App) time.Duration {
+	maxAttempts, maxAttemptsPerIp, _, _ := loginLimits(app)
+	failures := max(t.usernameFailures, t.ipFailures*maxAttempts/maxAttemptsPerIp)
+	if failures == 0 {
+		return 0
+	}
+
+	delay := loginDelayStep
+	for i := 1; i < failures && delay < loginDelayMax; i++ {
+		delay *= 2
+	}
+
+	return min(delay, loginDelayMax)
+}
+
+// loginGuard is a login attempt being checked. The attempt is stored as failed before the throttle is checked, so
+// concurrent attempts count each other and a burst can't get past the limits before its failures are written
+type loginGuard struct {
+	attempt  LoginAttempt
+	throttle loginThrottle
+}
+
+// guardLogin records an attempt of the username from the IP address and checks the throttle against the attempts
+// before it. ErrTooManyLoginAttempts is returned while locked out, the attempt then isn't kept. Otherwise the caller
+// waits for the delay and ends the attempt with succeeded, failed or discard
+func guardLogin(ctx context.Context, app *app.App, username string, ip string) (*loginGuard, error) {
+	guard := &loginGuard{attempt: LoginAttempt{Username: username, Ip: ip}}
+	err := database.Insert(ctx, app, &guard.attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	guard.throttle, err = checkLoginThrottle(ctx, app, guard.attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	if guard.throttle.locked {
+		guard.discard(ctx, app) // Refused attempts don't extend the lockout
+		return nil, ErrTooManyLoginAttempts
+	}
+
+	err = waitLoginDelay(ctx, app, guard.throttle)
+	if err != nil {
+		return nil, err
+	}
+
+	return guard, nil
+}
+
+// checkLoginThrottle looks up the failed logins of the username and from the IP address recorded before the attempt,
+// including ones still being checked. Username failures are only counted since its last successful login
+func checkLoginThrottle(ctx context.Context, app *app.App, attempt LoginAttempt) (loginThrottle, error) {
+	ctx = database.WithPrimary(ctx)
+	maxAttempts, maxAttemptsPerIp, window, lockout := loginLimits(app)
+	now := time.Now()
+	since := now.Add(-window)
+
+	lastSuccess, err := database.From[LoginAttempt](app).
+		Where("\"Username\" = ? AND \"Succeeded\" = ? AND \"CreatedAt\" > ?", attempt.Username, true, since).
+		OrderBy("\"CreatedAt\" DESC").First(ctx)
+	if err == nil {
+		since = lastSuccess.CreatedAt
+	}
+
+	byUsername, err := database.From[LoginAttempt](app).
+		Where("\"Username\" = ? AND \"Succeeded\" = ? AND \"CreatedAt\" > ? AND \"Id\" <> ?", attempt.Username, false, since, attempt.Id).
+		OrderBy("\"CreatedAt\" DESC").Limit(maxAttempts).All(ctx)
+	if err != nil {
+		return loginThrottle{}, err
+	}
+
+	byIp, err := database.From[LoginAttempt](app).
+		Where("\"Ip\" = ? AND \"Succeeded\" = ? AND \"CreatedAt\" > ? AND \"Id\" <> ?", attempt.Ip, false, now.Add(-window), attempt.Id).
+		OrderBy("\"CreatedAt\" DESC").Limit(maxAttemptsPerIp).All(ctx)
+	if err != nil {
+		return loginThrottle{}, err
+	}
+
+	throttle := loginThrottle{usernameFailures: len(byUsername), ipFailures: len(byIp)}
+	if len(byUsername) >= maxAttempts && byUsername[0].CreatedAt.After(now.Add(-lockout)) {
+		throttle.locked = true
+	}
+	if len(byIp) >= maxAttemptsPerIp && byIp[0].CreatedAt.After(now.Add(-lockout)) {
+		throttle.locked = true
+	}
+
+	if throttle.locked {
+		slog.Warn("security: login refused while locked out", "username", attempt.Username, "ip", attempt.Ip)
+	}
+
+	return throttle, nil
+}
+
+// succeeded marks the attempt as a successful login, which resets the failures counted for the username
+func (g *loginGuard) succeeded(ctx context.Context, app *app.App) {
+	g.attempt.Succeeded = true
+	err := database.Update(ctx, app, &g.attempt)
+	if err != nil {
+		slog.Error("error recording login attempt: " + err.Error())
+	}
+}
+
+// failed keeps the attempt as a failed login and logs it as a security event, together with the lockout it causes
+func (g *loginGuard) failed(app *app.App) {
+	username, ip, throttle := g.attempt.Username, g.attempt.Ip, g.throttle
+	slog.Warn("security: failed login", "username", username, "ip", ip, "usernameFailures", throttle.usernameFailures+1, "ipFailures", throttle.ipFailures+1)
+	maxAttempts, maxAttemptsPerIp, _, lockout := loginLimits(app)
+	if throttle.usernameFailures+1 >= maxAttempts {
+		slog.Warn("security: username locked out", "username", username, "ip", ip, "duration", lockout.String())
+	}
+	if throttle.ipFailures+1 >= maxAttemptsPerIp {
+		slog.Warn("security: ip address locked out", "username", username, "ip", ip, "duration", lockout.String())
+	}
+}
+
+// discard forgets the attempt, for checks that neither failed nor completed a login such as a password accepted
+// while the second factor is still missing
+func (g *loginGuard) discard(ctx context.Context, app *app.App) {
+	err := database.Delete(ctx, app, &g.attempt)
+	if err != nil {
+		slog.Error("error discarding login attempt: " + err.Error())
+	}
+}
+
+// waitLoginDelay sleeps for the delay of the throttle, returning early when the request is cancelled
+func waitLoginDelay(ctx context.Context, app *app.App, throttle loginThrottle) error {
+	delay := throttle.delay(app)
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rejectUnknownUser does the same work as checking a password, then returns ErrInvalidCredentials
+func rejectUnknownUser(password string) error {
+	_ = checkPassword(dummyPasswordHash(), password)
+	return ErrInvalidCredentials
+}
+
+// loginLimits returns the configured login throttling limits with defaults for unset ones
+func loginLimits(app *app.App) (maxAttempts int, maxAttemptsPerIp int, window time.Duration, lockout time.Duration) {
+	c := app.Config.Login
+	maxAttempts, maxAttemptsPerIp, window, lockout = defaultLoginMaxAttempts, defaultLoginMaxAttemptsPerIp, defaultLoginAttemptWindow, defaultLoginLockoutDuration
+
+	if c.MaxAttempts > 0 {
+		maxAttempts = c.MaxAttempts
+	}
+	if c.MaxAttemptsPerIp > 0 {
+		maxAttemptsPerIp = c.MaxAttemptsPerIp
+	}
+	if c.AttemptWindow > 0 {
+		window = time.Duration(c.AttemptWindow) * time.Second
+	}
+	if c.LockoutDuration > 0 {
+		lockout = time.Duration(c.LockoutDuration) * time.Second
+	}
+
+	return maxAttempts, maxAttemptsPerIp, window, lockout
+}
+
+// ScheduledLoginAttemptCleanup deletes login attempts older than a day, long after they stop counting
+func ScheduledLoginAttemptCleanup(app *app.App) {
+	err := database.EachTenant(context.Background(), app, func(ctx context.Context) error {
+		_, err := database.From[LoginAttempt](app).Where("\"CreatedAt\" < ?", time.Now().AddDate(0, 0, -1)).Delete(ctx)
+		return err
+	})
+	if err != nil {
+		slog.Error("error deleting old login attempts from database: " + err.Error())
+		return
+	}
+
+	slog.Info("deleted old login attempts from database")
+}
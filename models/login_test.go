@@ -0,0 +1,89 @@
+package models_test
+
+import (
+	"GoWeb/database"
+	"GoWeb/models"
+	"GoWeb/testsupport"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+var testClient = models.Client{Ip: "192.0.2.1", UserAgent: "test"}
+
+func TestAuthenticateUserUnknownAndWrongPasswordDoSameWork(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	user := testsupport.NewUser(t, ctx, app)
+
+	compares := models.CountHashCompares(t)
+	_, err := models.AuthenticateUser(ctx, app, httptest.NewRecorder(), "nobody", "wrong", false, testClient)
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Fatalf("unknown user: got %v, want ErrInvalidCredentials", err)
+	}
+	unknown := *compares
+
+	*compares = 0
+	_, err = models.AuthenticateUser(ctx, app, httptest.NewRecorder(), user.Username, "wrong", false, testClient)
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Fatalf("wrong password: got %v, want ErrInvalidCredentials", err)
+	}
+
+	if unknown != 1 || *compares != 1 {
+		t.Fatalf("got %d compares for an unknown user and %d for a wrong password, want 1 each", unknown, *compares)
+	}
+}
+
+func TestAuthenticateUserLocksOutAfterFailedLogins(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	user := testsupport.NewUser(t, ctx, app)
+
+	// Fewer attempts keep the growing delay between them short
+	maxAttempts := app.Config.Login.MaxAttempts
+	app.Config.Login.MaxAttempts = 2
+	t.Cleanup(func() { app.Config.Login.MaxAttempts = maxAttempts })
+
+	for i := 0; i < 2; i++ {
+		_, err := models.AuthenticateUser(ctx, app, httptest.NewRecorder(), user.Username, "wrong", false, testClient)
+		if !errors.Is(err, models.ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: got %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+
+	_, err := models.AuthenticateUser(ctx, app, httptest.NewRecorder(), user.Username, testsupport.DefaultPassword, false, testClient)
+	if !errors.Is(err, models.ErrTooManyLoginAttempts) {
+		t.Fatalf("right password while locked out: got %v, want ErrTooManyLoginAttempts", err)
+	}
+
+	other := models.Client{Ip: "192.0.2.2", UserAgent: "test"}
+	_, err = models.AuthenticateUser(ctx, app, httptest.NewRecorder(), user.Username, testsupport.DefaultPassword, false, other)
+	if !errors.Is(err, models.ErrTooManyLoginAttempts) {
+		t.Fatalf("right password from another address: got %v, want ErrTooManyLoginAttempts", err)
+	}
+
+	// Every attempt is stored before the throttle is checked, refused ones are dropped again
+	attempts, err := database.From[models.LoginAttempt](app).Where("\"Username\" = ?", user.Username).Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("got %d stored attempts, want the 2 failures", attempts)
+	}
+}
+
+func TestAuthenticateUserSucceedsBelowLimit(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	user := testsupport.NewUser(t, ctx, app)
+
+	_, err := models.AuthenticateUser(ctx, app, httptest.NewRecorder(), user.Username, "wrong", false, testClient)
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials", err)
+	}
+
+	session, err := models.AuthenticateUser(ctx, app, httptest.NewRecorder(), user.Username, testsupport.DefaultPassword, false, testClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.UserId != user.Id {
+		t.Fatalf("session belongs to user %d, want %d", session.UserId, user.Id)
+	}
+}
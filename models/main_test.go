@@ -0,0 +1,10 @@
+package models_test
+
+import (
+	"GoWeb/testsupport"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	testsupport.Main(m)
+}
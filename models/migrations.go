@@ -3,34 +3,137 @@ package models
 import (
 	"GoWeb/app"
 	"GoWeb/database"
+	"database/sql"
+	"errors"
 	"time"
 )
 
-// RunAllMigrations defines the structs that should be represented in the database
-func RunAllMigrations(app *app.App) error {
-	// Declare new dummy user for reflection
+// migrationDummies returns a dummy of every struct that should be represented in the database, fields are only
+// migrated when they are NOT the zero value in the dummy
+func migrationDummies() []any {
 	user := User{
-		Id:        1, // Id is handled automatically, but it is added here to show it will be skipped during column creation
-		Username:  "migrate",
-		Password:  "migrate",
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-	err := database.Migrate(app, user)
-	if err != nil {
-		return err
+		Id:              1, // Id is handled automatically, but it is added here to show it will be skipped during column creation
+		Username:        "migrate",
+		Email:           sql.NullString{String: "migrate", Valid: true},
+		EmailVerifiedAt: sql.NullTime{Time: time.Now(), Valid: true},
+		Password:        "migrate",
+		TotpSecret:      "migrate",
+		TotpEnabledAt:   sql.NullTime{Time: time.Now(), Valid: true},
+		TotpLastStep:    1,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+		DeletedAt:       sql.NullTime{Time: time.Now(), Valid: true},
 	}
 
 	session := Session{
 		Id:         1,
 		UserId:     1,
 		AuthToken:  "migrate",
-		RememberMe: false,
+		RememberMe: true,
+		Ip:         "migrate",
+		UserAgent:  "migrate",
+		LastSeenAt: time.Now(),
 		CreatedAt:  time.Now(),
 	}
-	err = database.Migrate(app, session)
-	if err != nil {
-		return err
+
+	auditLog := database.AuditLog{
+		Id:        1,
+		ActorId:   sql.NullInt64{Int64: 1, Valid: true},
+		Action:    "migrate",
+		TableName: "migrate",
+		RowId:     "migrate",
+		Changes:   "migrate",
+		CreatedAt: time.Now(),
+	}
+
+	userToken := UserToken{
+		Id:        1,
+		UserId:    1,
+		Purpose:   "migrate",
+		TokenHash: "migrate",
+		ExpiresAt: time.Now(),
+		CreatedAt: time.Now(),
+	}
+
+	recoveryCode := RecoveryCode{
+		Id:        1,
+		UserId:    1,
+		CodeHash:  "migrate",
+		CreatedAt: time.Now(),
+	}
+
+	pendingAuth := PendingAuth{
+		Id:         1,
+		UserId:     1,
+		TokenHash:  "migrate",
+		RememberMe: true,
+		Attempts:   1,
+		ExpiresAt:  time.Now(),
+		CreatedAt:  time.Now(),
+	}
+
+	role := Role{
+		Id:          1,
+		Name:        "migrate",
+		Description: "migrate",
+		CreatedAt:   time.Now(),
+	}
+
+	permission := Permission{
+		Id:          1,
+		Name:        "migrate",
+		Description: "migrate",
+		CreatedAt:   time.Now(),
+	}
+
+	userRole := UserRole{
+		UserId:    1,
+		RoleId:    1,
+		CreatedAt: time.Now(),
+	}
+
+	rolePermission := RolePermission{
+		RoleId:       1,
+		PermissionId: 1,
+		CreatedAt:    time.Now(),
+	}
+
+	loginAttempt := LoginAttempt{
+		Id:        1,
+		Username:  "migrate",
+		Ip:        "migrate",
+		Succeeded: true,
+		CreatedAt: time.Now(),
+	}
+
+	return []any{user, session, auditLog, userToken, recoveryCode, pendingAuth, role, permission, userRole, rolePermission, loginAttempt}
+}
+
+// RunAllMigrations creates the tables and columns of every struct returned by migrationDummies
+func RunAllMigrations(app *app.App) error {
+	for _, dummy := range migrationDummies() {
+		err := database.Migrate(app, dummy)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunAllTenantMigrations runs every migration in the schema of each configured tenant, creating missing schemas
+func RunAllTenantMigrations(app *app.App) error {
+	for _, tenant := range app.Config.Tenancy.Tenants {
+		if !database.IsTenant(app, tenant) {
+			return errors.New("invalid tenant name: " + tenant)
+		}
+
+		tenantApp := *app
+		tenantApp.Config.Db.Schema = database.TenantSchema(tenant)
+		err := RunAllMigrations(&tenantApp)
+		if err != nil {
+			return errors.New("tenant " + tenant + ": " + err.Error())
+		}
 	}
 
 	return nil
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Migrate skips fields that are zero in their dummy, so a zero field would never get a column on a fresh database
+func TestMigrationDummiesSetEveryColumn(t *testing.T) {
+	for _, dummy := range migrationDummies() {
+		value := reflect.ValueOf(dummy)
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			tag := field.Tag.Get("db")
+			if strings.HasPrefix(tag, "hasMany") || strings.HasPrefix(tag, "belongsTo") || strings.HasPrefix(tag, "manyToMany") {
+				continue
+			}
+
+			if value.Field(i).IsZero() {
+				t.Errorf("%s.%s is zero in its migration dummy and wouldn't be migrated", value.Type().Name(), field.Name)
+			}
+		}
+	}
+}
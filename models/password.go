@@ -0,0 +1,162 @@
+package models
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"GoWeb/mail"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTtl is how long an emailed password reset link works
+const passwordResetTtl = time.Hour
+
+// RequestPasswordReset emails a password reset link to the user with the username or email address. Unknown users
+// and users without an email address are only logged so callers can answer every request the same way without
+// revealing which accounts exist
+func RequestPasswordReset(ctx context.Context, app *app.App, login string) error {
+	user, err := UserByUsername(ctx, app, login)
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = UserByEmail(ctx, app, login)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.Info("password reset requested for unknown user: " + login)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !user.Email.Valid {
+		slog.Info("password reset requested for user without email address", "user", user.Id)
+		return nil
+	}
+
+	token, err := CreateUserToken(ctx, app, user.Id, TokenPasswordReset, passwordResetTtl)
+	if err != nil {
+		return err
+	}
+
+	link := siteUrl(app, "/reset-password?token="+url.QueryEscape(token))
+	return app.Mailer.Send(ctx, mail.Message{
+		To:      user.Email.String,
+		Subject: "Reset your password",
+		Body: "Someone asked to reset the password of your account. Open the link below within an hour to choose a new " +
+			"password, or ignore this email if it wasn't you.\n\n" + link + "\n",
+	})
+}
+
+// ResetPassword sets the password of the user a password reset token was created for and logs them out everywhere
+// by deleting all of their sessions, the token is used up. ErrInvalidToken is returned for unknown or expired tokens
+func ResetPassword(ctx context.Context, app *app.App, token string, password string) error {
+	return database.WithTransaction(ctx, app, func(ctx context.Context) error {
+		userToken, err := ConsumeUserToken(ctx, app, TokenPasswordReset, token)
+		if err != nil {
+			return err
+		}
+
+		user, err := UserById(ctx, app, userToken.UserId)
+		if err != nil {
+			return err
+		}
+
+		err = setPassword(ctx, app, &user, password)
+		if err != nil {
+			return err
+		}
+
+		slog.Info("password reset", "user", user.Id)
+		return nil
+	})
+}
+
+// ChangePassword sets a new password for the user after checking their current one and logs them out everywhere,
+// including the session the change was made in. ErrInvalidCredentials is returned if current is wrong, wrong current
+// passwords count as failed logins of the user from the client, so ErrTooManyLoginAttempts is returned once locked out
+func ChangePassword(ctx context.Context, app *app.App, user *User, current string, password string, client Client) error {
+	guard, err := guardLogin(ctx, app, strings.ToLower(user.Username), client.Ip)
+	if err != nil {
+		return err
+	}
+
+	err = checkPassword(user.Password, current)
+	if err != nil {
+		slog.Warn("security: incorrect current password on password change", "user", user.Id)
+		guard.failed(app)
+		return ErrInvalidCredentials
+	}
+	guard.discard(ctx, app)
+
+	err = database.WithTransaction(ctx, app, func(ctx context.Context) error {
+		return setPassword(ctx, app, user, password)
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("password changed", "user", user.Id)
+	return nil
+}
+
+// setPassword stores the new password of the user and revokes all of their sessions, so anyone who knew the old
+// password is logged out. Call it inside a transaction
+func setPassword(ctx context.Context, app *app.App, user *User, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user.Password = hash
+	err = database.Update(ctx, app, user)
+	if err != nil {
+		return err
+	}
+
+	return revokeAllSessions(ctx, app, user.Id)
+}
+
+// hashPassword returns the hash of the password to store, the password is hashed with sha256 first so bcrypt's 72
+// byte input limit doesn't truncate long passwords
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(prehashPassword(password)), bcrypt.DefaultCost)
+	if err != nil {
+		slog.Error("error hashing password: " + err.Error())
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// compareHash is the bcrypt comparison behind every password check, a variable so tests can count the comparisons
+var compareHash = bcrypt.CompareHashAndPassword
+
+// checkPassword reports whether password matches a hash created by hashPassword
+func checkPassword(hash string, password string) error {
+	return compareHash([]byte(hash), []byte(prehashPassword(password)))
+}
+
+// prehashPassword returns the hex encoded sha256 hash of the password
+func prehashPassword(password string) string {
+	hash256 := sha256.New()
+	hash256.Write([]byte(password))
+	return hex.EncodeToString(hash256.Sum(nil))
+}
+
+// siteUrl returns the absolute URL of a path on the site for links sent by email
+func siteUrl(app *app.App, path string) string {
+	base := app.Config.BaseUrl
+	if base == "" {
+		base = "http://" + app.Config.Listen.Ip + ":" + app.Config.Listen.Port
+	}
+
+	return strings.TrimSuffix(base, "/") + path
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRejectUnknownUserMatchesWrongPasswordWork(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compares := CountHashCompares(t)
+	if checkPassword(hash, "wrong") == nil {
+		t.Fatal("wrong password was accepted")
+	}
+	wrong := *compares
+
+	*compares = 0
+	if !errors.Is(rejectUnknownUser("wrong"), ErrInvalidCredentials) {
+		t.Fatal("unknown user wasn't rejected with ErrInvalidCredentials")
+	}
+
+	if wrong != 1 || *compares != 1 {
+		t.Fatalf("got %d compares for a wrong password and %d for an unknown user, want 1 each", wrong, *compares)
+	}
+}
+
+func TestCheckPasswordRejectsPrehash(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkPassword(hash, "correct horse") != nil {
+		t.Fatal("right password was rejected")
+	}
+
+	if checkPassword(hash, prehashPassword("correct horse")) == nil {
+		t.Fatal("sha256 of the password was accepted as the password")
+	}
+}
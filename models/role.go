@@ -0,0 +1,178 @@
+package models
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Role is a named set of permissions assigned to users, e.g. admin or editor
+type Role struct {
+	Id          int64
+	Name        string `index:"unique"`
+	Description string
+	CreatedAt   time.Time
+
+	Permissions []Permission `db:"manyToMany,join=RolePermission,fk=RoleId,ref=PermissionId"` // Loaded on request
+}
+
+// Permission is something a user may be allowed to do, named after the action, e.g. users.manage
+type Permission struct {
+	Id          int64
+	Name        string `index:"unique"`
+	Description string
+	CreatedAt   time.Time
+}
+
+// UserRole assigns a role to a user
+type UserRole struct {
+	UserId    int64 `db:"pk"`
+	RoleId    int64 `db:"pk"`
+	CreatedAt time.Time
+}
+
+// RolePermission grants a permission to everyone with a role
+type RolePermission struct {
+	RoleId       int64 `db:"pk"`
+	PermissionId int64 `db:"pk"`
+	CreatedAt    time.Time
+}
+
+var (
+	// ErrUnknownRole is returned when assigning or granting to a role that doesn't exist
+	ErrUnknownRole = errors.New("role does not exist")
+	// ErrUnknownPermission is returned when granting a permission that doesn't exist
+	ErrUnknownPermission = errors.New("permission does not exist")
+)
+
+// BeforeDelete takes the role away from its users and drops its permissions
+func (r *Role) BeforeDelete(ctx context.Context, app *app.App) error {
+	_, err := database.From[UserRole](app).Where("\"RoleId\" = ?", r.Id).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.From[RolePermission](app).Where("\"RoleId\" = ?", r.Id).Delete(ctx)
+	return err
+}
+
+// BeforeDelete takes the permission away from every role granting it
+func (p *Permission) BeforeDelete(ctx context.Context, app *app.App) error {
+	_, err := database.From[RolePermission](app).Where("\"PermissionId\" = ?", p.Id).Delete(ctx)
+	return err
+}
+
+// CreateRole creates a Role table row in the database
+func CreateRole(ctx context.Context, app *app.App, name string, description string) (Role, error) {
+	role := Role{Name: name, Description: description}
+	err := database.Insert(ctx, app, &role)
+	return role, err
+}
+
+// CreatePermission creates a Permission table row in the database
+func CreatePermission(ctx context.Context, app *app.App, name string, description string) (Permission, error) {
+	permission := Permission{Name: name, Description: description}
+	err := database.Insert(ctx, app, &permission)
+	return permission, err
+}
+
+// RoleByName finds a Role table row in the database by name, ErrUnknownRole is returned if there is none
+func RoleByName(ctx context.Context, app *app.App, name string) (Role, error) {
+	role, err := database.From[Role](app).Where("\"Name\" = ?", name).First(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Role{}, ErrUnknownRole
+	}
+
+	return role, err
+}
+
+// PermissionByName finds a Permission table row in the database by name, ErrUnknownPermission is returned if there
+// is none
+func PermissionByName(ctx context.Context, app *app.App, name string) (Permission, error) {
+	permission, err := database.From[Permission](app).Where("\"Name\" = ?", name).First(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Permission{}, ErrUnknownPermission
+	}
+
+	return permission, err
+}
+
+// Grant gives everyone with the role the named permission, granting it again does nothing
+func (r *Role) Grant(ctx context.Context, app *app.App, permissionName string) error {
+	permission, err := PermissionByName(ctx, app, permissionName)
+	if err != nil {
+		return err
+	}
+
+	return database.Upsert(ctx, app, &RolePermission{RoleId: r.Id, PermissionId: permission.Id})
+}
+
+// Revoke takes the named permission away from the role
+func (r *Role) Revoke(ctx context.Context, app *app.App, permissionName string) error {
+	permission, err := PermissionByName(ctx, app, permissionName)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.From[RolePermission](app).Where("\"RoleId\" = ? AND \"PermissionId\" = ?", r.Id, permission.Id).Delete(ctx)
+	return err
+}
+
+// AssignRole gives the user the named role, assigning it again does nothing
+func (u *User) AssignRole(ctx context.Context, app *app.App, roleName string) error {
+	role, err := RoleByName(ctx, app, roleName)
+	if err != nil {
+		return err
+	}
+
+	return database.Upsert(ctx, app, &UserRole{UserId: u.Id, RoleId: role.Id})
+}
+
+// RemoveRole takes the named role away from the user
+func (u *User) RemoveRole(ctx context.Context, app *app.App, roleName string) error {
+	role, err := RoleByName(ctx, app, roleName)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.From[UserRole](app).Where("\"UserId\" = ? AND \"RoleId\" = ?", u.Id, role.Id).Delete(ctx)
+	return err
+}
+
+// LoadAccess loads the roles of the user together with their permissions, which HasRole and Can check
+func (u *User) LoadAccess(ctx context.Context, app *app.App) error {
+	err := database.Load(ctx, app, u, "Roles")
+	if err != nil {
+		return err
+	}
+
+	return database.Load(ctx, app, &u.Roles, "Permissions")
+}
+
+// HasRole reports whether the user has the named role, the roles have to be loaded with LoadAccess first
+func (u User) HasRole(name string) bool {
+	for _, role := range u.Roles {
+		if role.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Can reports whether any role of the user grants the named permission, the roles have to be loaded with LoadAccess
+// first. Users without roles can do nothing
+func Can(user User, permission string) bool {
+	for _, role := range user.Roles {
+		for _, granted := range role.Permissions {
+			if granted.Name == permission {
+				return true
+			}
+		}
+	}
+
+	return false
+}
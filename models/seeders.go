@@ -0,0 +1,21 @@
+package models
+
+import (
+	"GoWeb/database"
+	"embed"
+)
+
+//go:embed fixtures
+var fixtures embed.FS
+
+// RegisterAllSeeders defines the seeders that populate a development database, they run in the order listed here
+func RegisterAllSeeders() {
+	// Development account, the password is "password"
+	database.RegisterFixture[User]("users", fixtures, "fixtures/users.json")
+
+	// Admin role granting every permission, given to the development account
+	database.RegisterFixture[Role]("roles", fixtures, "fixtures/roles.json")
+	database.RegisterFixture[Permission]("permissions", fixtures, "fixtures/permissions.json")
+	database.RegisterFixture[RolePermission]("role-permissions", fixtures, "fixtures/role_permissions.json")
+	database.RegisterFixture[UserRole]("user-roles", fixtures, "fixtures/user_roles.json")
+}
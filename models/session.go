@@ -2,6 +2,9 @@ package models
 
 import (
 	"GoWeb/app"
+	"GoWeb/database"
+	"GoWeb/security"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"log/slog"
@@ -14,33 +17,46 @@ type Session struct {
 	UserId     int64
 	AuthToken  string
 	RememberMe bool
+	Ip         string    // Address the session was last used from
+	UserAgent  string    // Browser the session was created in
+	LastSeenAt time.Time // Updated at most once per sessionTouchInterval
 	CreatedAt  time.Time
-}
 
-const sessionColumnsNoId = "\"UserId\", \"AuthToken\", \"RememberMe\", \"CreatedAt\""
-const sessionColumns = "\"Id\", " + sessionColumnsNoId
-const sessionTable = "public.\"Session\""
+	User *User `db:"belongsTo,fk=UserId"` // Loaded on request
+}
 
 const (
-	selectSessionByAuthToken      = "SELECT " + sessionColumns + " FROM " + sessionTable + " WHERE \"AuthToken\" = $1"
-	selectAuthTokenIfExists       = "SELECT EXISTS(SELECT 1 FROM " + sessionTable + " WHERE \"AuthToken\" = $1)"
-	insertSession                 = "INSERT INTO " + sessionTable + " (" + sessionColumnsNoId + ") VALUES ($1, $2, $3, $4) RETURNING \"Id\""
-	deleteSessionByAuthToken      = "DELETE FROM " + sessionTable + " WHERE \"AuthToken\" = $1"
-	deleteSessionsOlderThan30Days = "DELETE FROM " + sessionTable + " WHERE \"CreatedAt\" < NOW() - INTERVAL '30 days'"
-	deleteSessionsOlderThan6Hours = "DELETE FROM " + sessionTable + " WHERE \"CreatedAt\" < NOW() - INTERVAL '6 hours' AND \"RememberMe\" = false"
+	rememberedSessionLifetime = 30 * 24 * time.Hour // How long "remember me" sessions last
+	sessionLifetime           = 6 * time.Hour       // How long other sessions last
+	sessionTouchInterval      = time.Minute         // How stale LastSeenAt may get before a request updates it, so not every request writes
 )
 
+// Client identifies the device a request comes from
+type Client struct {
+	Ip        string
+	UserAgent string
+}
+
+// ClientOf returns the device the request comes from, see security.ClientIp for the address
+func ClientOf(app *app.App, r *http.Request) Client {
+	return Client{
+		Ip:        security.ClientIp(r, app.Config.Listen.ClientIpHeader, app.Config.Listen.Proxies),
+		UserAgent: r.UserAgent(),
+	}
+}
+
 // CreateSession creates a new session for a user
-func CreateSession(app *app.App, w http.ResponseWriter, userId int64, remember bool) (Session, error) {
+func CreateSession(ctx context.Context, app *app.App, w http.ResponseWriter, userId int64, remember bool, client Client) (Session, error) {
 	session := Session{}
 	session.UserId = userId
 	session.AuthToken = generateAuthToken(app)
 	session.RememberMe = remember
-	session.CreatedAt = time.Now()
+	session.Ip = client.Ip
+	session.UserAgent = client.UserAgent
+	session.LastSeenAt = time.Now()
 
 	// If the AuthToken column for any user matches the token, set existingAuthToken to true
-	var existingAuthToken bool
-	err := app.Db.QueryRow(selectAuthTokenIfExists, session.AuthToken).Scan(&existingAuthToken)
+	existingAuthToken, err := database.From[Session](app).Where("\"AuthToken\" = ?", session.AuthToken).Exists(database.WithPrimary(ctx))
 	if err != nil {
 		slog.Error("error checking for existing auth token" + err.Error())
 		return Session{}, err
@@ -49,10 +65,10 @@ func CreateSession(app *app.App, w http.ResponseWriter, userId int64, remember b
 	// If duplicate token found, recursively call function until unique token is generated
 	if existingAuthToken {
 		slog.Warn("duplicate token found in sessions table, generating new token...")
-		return CreateSession(app, w, userId, remember)
+		return CreateSession(ctx, app, w, userId, remember, client)
 	}
 
-	err = app.Db.QueryRow(insertSession, session.UserId, session.AuthToken, session.RememberMe, session.CreatedAt).Scan(&session.Id)
+	err = database.Insert(ctx, app, &session)
 	if err != nil {
 		slog.Error("error inserting session into database")
 		return Session{}, err
@@ -62,15 +78,70 @@ func CreateSession(app *app.App, w http.ResponseWriter, userId int64, remember b
 	return session, nil
 }
 
-func SessionByAuthToken(app *app.App, authToken string) (Session, error) {
-	session := Session{}
+// SessionByAuthToken finds a Session table row in the database by AuthToken and returns a struct representing this row
+func SessionByAuthToken(ctx context.Context, app *app.App, authToken string) (Session, error) {
+	return database.From[Session](app).Where("\"AuthToken\" = ?", authToken).First(ctx)
+}
 
-	err := app.Db.QueryRow(selectSessionByAuthToken, authToken).Scan(&session.Id, &session.UserId, &session.AuthToken, &session.RememberMe, &session.CreatedAt)
+// CurrentSession finds the session of the request by session cookie
+func CurrentSession(app *app.App, r *http.Request) (Session, error) {
+	cookie, err := r.Cookie("session")
 	if err != nil {
 		return Session{}, err
 	}
 
-	return session, nil
+	return SessionByAuthToken(r.Context(), app, cookie.Value)
+}
+
+// ActiveSessions returns the sessions of the user that haven't expired, most recently used first
+func ActiveSessions(ctx context.Context, app *app.App, userId int64) ([]Session, error) {
+	return database.From[Session](app).
+		Where("\"UserId\" = ? AND \"CreatedAt\" >= ? AND (\"RememberMe\" = ? OR \"CreatedAt\" >= ?)", userId, time.Now().Add(-rememberedSessionLifetime), true, time.Now().Add(-sessionLifetime)).
+		OrderBy("\"LastSeenAt\" DESC, \"Id\" DESC").
+		All(ctx)
+}
+
+// RevokeSession logs the user out of one of their sessions, sessions of other users are left alone
+func RevokeSession(ctx context.Context, app *app.App, userId int64, sessionId int64) error {
+	_, err := database.From[Session](app).Where("\"Id\" = ? AND \"UserId\" = ?", sessionId, userId).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("session revoked", "user", userId, "session", sessionId)
+	return nil
+}
+
+// RevokeOtherSessions logs the user out everywhere except in the session with the AuthToken
+func RevokeOtherSessions(ctx context.Context, app *app.App, userId int64, authToken string) error {
+	revoked, err := database.From[Session](app).Where("\"UserId\" = ? AND \"AuthToken\" <> ?", userId, authToken).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("other sessions revoked", "user", userId, "sessions", revoked)
+	return nil
+}
+
+// revokeAllSessions logs the user out everywhere
+func revokeAllSessions(ctx context.Context, app *app.App, userId int64) error {
+	_, err := database.From[Session](app).Where("\"UserId\" = ?", userId).Delete(ctx)
+	return err
+}
+
+// TouchSession records that the session was just used from the client, skipped while LastSeenAt is recent. The auth
+// middleware calls it once per request
+func TouchSession(ctx context.Context, app *app.App, session Session, client Client) {
+	if time.Since(session.LastSeenAt) < sessionTouchInterval && session.Ip == client.Ip {
+		return
+	}
+
+	session.LastSeenAt = time.Now()
+	session.Ip = client.Ip
+	err := database.Update(ctx, app, &session)
+	if err != nil {
+		slog.Error("error updating last seen time of session: " + err.Error())
+	}
 }
 
 // generateAuthToken generates a random 64-byte string
@@ -92,7 +163,7 @@ func createSessionCookie(app *app.App, w http.ResponseWriter, session Session) {
 			Name:     "session",
 			Value:    session.AuthToken,
 			Path:     "/",
-			MaxAge:   2592000 * 1000, // 30 days in ms
+			MaxAge:   int(rememberedSessionLifetime.Seconds()),
 			HttpOnly: true,
 			Secure:   true,
 		}
@@ -101,7 +172,7 @@ func createSessionCookie(app *app.App, w http.ResponseWriter, session Session) {
 			Name:     "session",
 			Value:    session.AuthToken,
 			Path:     "/",
-			MaxAge:   21600 * 1000, // 6 hours in ms
+			MaxAge:   int(sessionLifetime.Seconds()),
 			HttpOnly: true,
 			Secure:   true,
 		}
@@ -123,8 +194,8 @@ func deleteSessionCookie(app *app.App, w http.ResponseWriter) {
 }
 
 // DeleteSessionByAuthToken deletes a session from the database by AuthToken
-func DeleteSessionByAuthToken(app *app.App, w http.ResponseWriter, authToken string) error {
-	_, err := app.Db.Exec(deleteSessionByAuthToken, authToken)
+func DeleteSessionByAuthToken(ctx context.Context, app *app.App, w http.ResponseWriter, authToken string) error {
+	_, err := database.From[Session](app).Where("\"AuthToken\" = ?", authToken).Delete(ctx)
 	if err != nil {
 		slog.Error("error deleting session from database")
 		return err
@@ -137,16 +208,25 @@ func DeleteSessionByAuthToken(app *app.App, w http.ResponseWriter, authToken str
 
 // ScheduledSessionCleanup deletes expired sessions from the database
 func ScheduledSessionCleanup(app *app.App) {
-	// Delete sessions older than 30 days (remember me sessions)
-	_, err := app.Db.Exec(deleteSessionsOlderThan30Days)
-	if err != nil {
-		slog.Error("error deleting 30 day expired sessions from database" + err.Error())
-	}
+	err := database.EachTenant(context.Background(), app, func(ctx context.Context) error {
+		// Delete expired remember me sessions
+		_, err := database.From[Session](app).Where("\"CreatedAt\" < ?", time.Now().Add(-rememberedSessionLifetime)).Delete(ctx)
+		if err != nil {
+			slog.Error("error deleting expired remember me sessions from database" + err.Error())
+			return err
+		}
+
+		// Delete expired sessions
+		_, err = database.From[Session](app).Where("\"CreatedAt\" < ? AND \"RememberMe\" = ?", time.Now().Add(-sessionLifetime), false).Delete(ctx)
+		if err != nil {
+			slog.Error("error deleting expired sessions from database" + err.Error())
+			return err
+		}
 
-	// Delete sessions older than 6 hours
-	_, err = app.Db.Exec(deleteSessionsOlderThan6Hours)
+		return nil
+	})
 	if err != nil {
-		slog.Error("error deleting 6 hour expired sessions from database" + err.Error())
+		return
 	}
 
 	slog.Info("deleted expired sessions from database")
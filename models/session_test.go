@@ -0,0 +1,60 @@
+package models_test
+
+import (
+	"GoWeb/models"
+	"GoWeb/testsupport"
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChangePasswordRevokesEverySession(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	user := testsupport.NewUser(t, ctx, app)
+	other := testsupport.NewUser(t, ctx, app)
+
+	sessions := []models.Session{
+		testsupport.NewSession(t, ctx, app, user, false),
+		testsupport.NewSession(t, ctx, app, user, true),
+	}
+	kept := testsupport.NewSession(t, ctx, app, other, false)
+
+	err := models.ChangePassword(ctx, app, &user, testsupport.DefaultPassword, "new password", testClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, session := range sessions {
+		_, err = models.SessionByAuthToken(ctx, app, session.AuthToken)
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("session %d: got %v, want it revoked", session.Id, err)
+		}
+	}
+
+	_, err = models.SessionByAuthToken(ctx, app, kept.AuthToken)
+	if err != nil {
+		t.Fatalf("session of another user was revoked: %v", err)
+	}
+
+	_, err = models.AuthenticateUser(ctx, app, httptest.NewRecorder(), user.Username, "new password", false, testClient)
+	if err != nil {
+		t.Fatalf("new password was rejected: %v", err)
+	}
+}
+
+func TestChangePasswordRejectsWrongCurrentPassword(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	user := testsupport.NewUser(t, ctx, app)
+	session := testsupport.NewSession(t, ctx, app, user, false)
+
+	err := models.ChangePassword(ctx, app, &user, "wrong", "new password", testClient)
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials", err)
+	}
+
+	_, err = models.SessionByAuthToken(ctx, app, session.AuthToken)
+	if err != nil {
+		t.Fatalf("session was revoked after a failed change: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Purposes of user tokens, a token only works for the purpose it was created for
+const (
+	TokenPasswordReset     = "password-reset"
+	TokenEmailVerification = "email-verification"
+)
+
+// UserToken is a single-use secret emailed to a user to prove they control their account, only a hash of the token is
+// stored so a leaked table can't be used to take over accounts
+type UserToken struct {
+	Id        int64
+	UserId    int64
+	Purpose   string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// ErrInvalidToken is returned when a token doesn't exist, has expired, was already used or is for another purpose
+var ErrInvalidToken = errors.New("token is invalid or has expired")
+
+// CreateUserToken creates a token for the user valid for ttl and returns it, earlier tokens of the user for the same
+// purpose stop working
+func CreateUserToken(ctx context.Context, app *app.App, userId int64, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	err = database.WithTransaction(ctx, app, func(ctx context.Context) error {
+		_, err := database.From[UserToken](app).Where("\"UserId\" = ? AND \"Purpose\" = ?", userId, purpose).Delete(ctx)
+		if err != nil {
+			return err
+		}
+
+		return database.Insert(ctx, app, &UserToken{
+			UserId:    userId,
+			Purpose:   purpose,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(ttl),
+		})
+	})
+	if err != nil {
+		slog.Error("error creating " + purpose + " token: " + err.Error())
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumeUserToken checks a token for the purpose and deletes it so it can't be used again, returning the user it
+// was created for. Of concurrent attempts to use the same token only one succeeds
+func ConsumeUserToken(ctx context.Context, app *app.App, purpose string, token string) (UserToken, error) {
+	userToken, err := database.From[UserToken](app).
+		Where("\"TokenHash\" = ? AND \"Purpose\" = ? AND \"ExpiresAt\" > ?", hashToken(token), purpose, time.Now()).
+		First(database.WithPrimary(ctx))
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserToken{}, ErrInvalidToken
+	}
+	if err != nil {
+		return UserToken{}, err
+	}
+
+	deleted, err := database.From[UserToken](app).Where("\"Id\" = ?", userToken.Id).Delete(ctx)
+	if err != nil {
+		return UserToken{}, err
+	}
+
+	if deleted == 0 { // Used by a concurrent request in the meantime
+		return UserToken{}, ErrInvalidToken
+	}
+
+	return userToken, nil
+}
+
+// ScheduledUserTokenCleanup deletes expired user tokens
+func ScheduledUserTokenCleanup(app *app.App) {
+	err := database.EachTenant(context.Background(), app, func(ctx context.Context) error {
+		_, err := database.From[UserToken](app).Where("\"ExpiresAt\" < ?", time.Now()).Delete(ctx)
+		return err
+	})
+	if err != nil {
+		slog.Error("error deleting expired user tokens from database: " + err.Error())
+		return
+	}
+
+	slog.Info("deleted expired user tokens from database")
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a token, tokens are random so they don't need a slow hash
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
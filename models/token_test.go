@@ -0,0 +1,52 @@
+package models_test
+
+import (
+	"GoWeb/models"
+	"GoWeb/testsupport"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConsumeUserTokenIsSingleUse(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	user := testsupport.NewUser(t, ctx, app)
+
+	token, err := models.CreateUserToken(ctx, app, user.Id, models.TokenPasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.ConsumeUserToken(ctx, app, models.TokenEmailVerification, token)
+	if !errors.Is(err, models.ErrInvalidToken) {
+		t.Fatalf("token used for another purpose: got %v, want ErrInvalidToken", err)
+	}
+
+	consumed, err := models.ConsumeUserToken(ctx, app, models.TokenPasswordReset, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumed.UserId != user.Id {
+		t.Fatalf("token belongs to user %d, want %d", consumed.UserId, user.Id)
+	}
+
+	_, err = models.ConsumeUserToken(ctx, app, models.TokenPasswordReset, token)
+	if !errors.Is(err, models.ErrInvalidToken) {
+		t.Fatalf("token used twice: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestConsumeUserTokenRejectsExpired(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	user := testsupport.NewUser(t, ctx, app)
+
+	token, err := models.CreateUserToken(ctx, app, user.Id, models.TokenPasswordReset, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.ConsumeUserToken(ctx, app, models.TokenPasswordReset, token)
+	if !errors.Is(err, models.ErrInvalidToken) {
+		t.Fatalf("got %v, want ErrInvalidToken", err)
+	}
+}
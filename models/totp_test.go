@@ -0,0 +1,80 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890" encoded for authenticator apps
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCheckTotpRfc6238Vectors(t *testing.T) {
+	// The RFC lists 8 digit codes, 6 digit codes are their last 6 digits
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, vector := range vectors {
+		now := time.Unix(vector.unix, 0)
+		step, ok := checkTotp(rfc6238Secret, vector.code, now, 0)
+		if !ok {
+			t.Errorf("code %s at %d was rejected", vector.code, vector.unix)
+			continue
+		}
+
+		if step != totpStep(now) {
+			t.Errorf("code %s at %d matched step %d, want %d", vector.code, vector.unix, step, totpStep(now))
+		}
+	}
+}
+
+func TestCheckTotpSkew(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	_, ok := checkTotp(rfc6238Secret, "081804", now.Add(totpPeriod), 0)
+	if !ok {
+		t.Error("code of the previous step was rejected")
+	}
+
+	_, ok = checkTotp(rfc6238Secret, "081804", now.Add(-totpPeriod), 0)
+	if !ok {
+		t.Error("code of the next step was rejected")
+	}
+
+	_, ok = checkTotp(rfc6238Secret, "081804", now.Add(2*totpPeriod), 0)
+	if ok {
+		t.Error("code from two steps ago was accepted")
+	}
+}
+
+func TestCheckTotpRejectsReplayAndWrongCodes(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	step, ok := checkTotp(rfc6238Secret, "005924", now, 0)
+	if !ok {
+		t.Fatal("code was rejected")
+	}
+
+	_, ok = checkTotp(rfc6238Secret, "005924", now, step)
+	if ok {
+		t.Error("code was accepted again after it was used")
+	}
+
+	_, ok = checkTotp(rfc6238Secret, "005925", now, 0)
+	if ok {
+		t.Error("wrong code was accepted")
+	}
+
+	_, ok = checkTotp("not base32!", "005924", now, 0)
+	if ok {
+		t.Error("code was accepted for an invalid secret")
+	}
+}
@@ -0,0 +1,449 @@
+package models
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// pendingAuthTtl is how long a user has to enter their code after their password was accepted
+	pendingAuthTtl = 5 * time.Minute
+	// pendingAuthMaxAttempts is how many wrong codes end a pending login, the password has to be entered again
+	pendingAuthMaxAttempts = 5
+	// recoveryCodeCount is how many recovery codes a user gets when enabling two-factor authentication
+	recoveryCodeCount = 10
+)
+
+var (
+	// ErrTwoFactorRequired is returned by AuthenticateUser when the password was right but the user has to enter a
+	// code from their authenticator app before being logged in
+	ErrTwoFactorRequired = errors.New("two-factor authentication code required")
+	// ErrInvalidTwoFactorCode is returned when a code is neither the current authenticator code nor an unused
+	// recovery code
+	ErrInvalidTwoFactorCode = errors.New("two-factor authentication code is invalid")
+	// ErrTwoFactorEnabled is returned when enrolling a user who already has two-factor authentication enabled
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTwoFactorDisabled is returned when managing two-factor authentication of a user who hasn't enabled it
+	ErrTwoFactorDisabled = errors.New("two-factor authentication is not enabled")
+)
+
+// RecoveryCode is a single-use code letting a user log in without their authenticator app, only a hash is stored
+type RecoveryCode struct {
+	Id        int64
+	UserId    int64
+	CodeHash  string
+	CreatedAt time.Time
+}
+
+// PendingAuth is a login waiting for its second factor, it is identified by a short-lived cookie so the password
+// doesn't have to be sent again with the code
+type PendingAuth struct {
+	Id         int64
+	UserId     int64
+	TokenHash  string
+	RememberMe bool
+	Attempts   int64
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
+// TwoFactorAvailable reports whether users can turn on two-factor authentication, which needs encryption keys since
+// the secrets are encrypted fields
+func TwoFactorAvailable() bool {
+	return database.EncryptionConfigured()
+}
+
+// TwoFactorEnabled reports whether the user has to enter a code from their authenticator app to log in
+func (u User) TwoFactorEnabled() bool {
+	return u.TotpEnabledAt.Valid
+}
+
+// BeginTwoFactorEnrollment gives the user an authenticator secret and returns the otpauth URI to add it to an
+// authenticator app with, two-factor authentication only takes effect once EnableTwoFactor confirms a code from the
+// app. Enrolling again before confirming keeps the secret so an app already set up keeps working
+func BeginTwoFactorEnrollment(ctx context.Context, app *app.App, user *User) (string, error) {
+	if user.TwoFactorEnabled() {
+		return "", ErrTwoFactorEnabled
+	}
+
+	if user.TotpSecret != "" {
+		return totpUri(app, *user), nil
+	}
+
+	secret, err := newTotpSecret()
+	if err != nil {
+		return "", err
+	}
+
+	user.TotpSecret = database.EncryptedString(secret)
+	err = database.Update(ctx, app, user)
+	if err != nil {
+		return "", err
+	}
+
+	return totpUri(app, *user), nil
+}
+
+// TwoFactorEnrollmentUri returns the otpauth URI of the secret BeginTwoFactorEnrollment gave the user, ok is false
+// when no enrollment is waiting for a code to confirm it
+func TwoFactorEnrollmentUri(app *app.App, user User) (uri string, ok bool) {
+	if user.TwoFactorEnabled() || user.TotpSecret == "" {
+		return "", false
+	}
+
+	return totpUri(app, user), true
+}
+
+// EnableTwoFactor turns on two-factor authentication for a user who began enrolling once code shows their app
+// generates the right codes, it returns the recovery codes to show the user. They are only stored hashed so this is
+// the only time they can be shown
+func EnableTwoFactor(ctx context.Context, app *app.App, user *User, code string) ([]string, error) {
+	if user.TwoFactorEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	if user.TotpSecret == "" {
+		return nil, ErrTwoFactorDisabled
+	}
+
+	step, ok := checkTotp(string(user.TotpSecret), code, time.Now(), user.TotpLastStep)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	var codes []string
+	err := database.WithTransaction(ctx, app, func(ctx context.Context) error {
+		user.TotpEnabledAt = sql.NullTime{Time: time.Now(), Valid: true}
+		user.TotpLastStep = step
+		err := database.Update(ctx, app, user)
+		if err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, app, user.Id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("two-factor authentication enabled", "user", user.Id)
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user after checking a current code, the old codes stop
+// working
+func RegenerateRecoveryCodes(ctx context.Context, app *app.App, user *User, code string) ([]string, error) {
+	var codes []string
+	err := database.WithTransaction(ctx, app, func(ctx context.Context) error {
+		err := verifySecondFactor(ctx, app, user, code)
+		if err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, app, user.Id)
+		return err
+	})
+
+	return codes, err
+}
+
+// DisableTwoFactor turns off two-factor authentication for the user after checking a current code
+func DisableTwoFactor(ctx context.Context, app *app.App, user *User, code string) error {
+	return database.WithTransaction(ctx, app, func(ctx context.Context) error {
+		err := verifySecondFactor(ctx, app, user, code)
+		if err != nil {
+			return err
+		}
+
+		return ResetTwoFactor(ctx, app, user)
+	})
+}
+
+// ResetTwoFactor turns off two-factor authentication for the user without asking for a code, deleting their secret,
+// recovery codes and pending logins. It is meant for administrators helping users who lost their device
+func ResetTwoFactor(ctx context.Context, app *app.App, user *User) error {
+	return database.WithTransaction(ctx, app, func(ctx context.Context) error {
+		user.TotpSecret = ""
+		user.TotpEnabledAt = sql.NullTime{}
+		user.TotpLastStep = 0
+		err := database.Update(ctx, app, user)
+		if err != nil {
+			return err
+		}
+
+		_, err = database.From[RecoveryCode](app).Where("\"UserId\" = ?", user.Id).Delete(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = database.From[PendingAuth](app).Where("\"UserId\" = ?", user.Id).Delete(ctx)
+		if err != nil {
+			return err
+		}
+
+		slog.Info("two-factor authentication reset", "user", user.Id)
+		return nil
+	})
+}
+
+// ResetTwoFactorByUsername resets two-factor authentication of the user with the username, tenant names the tenant
+// the user belongs to in multi-tenant mode and is ignored otherwise
+func ResetTwoFactorByUsername(ctx context.Context, app *app.App, username string, tenant string) error {
+	if username == "" {
+		return errors.New("username is required")
+	}
+
+	if app.Config.Tenancy.Enabled {
+		var err error
+		ctx, err = database.WithTenant(ctx, app, tenant)
+		if err != nil {
+			return err
+		}
+	}
+
+	user, err := UserByUsername(ctx, app, username)
+	if err != nil {
+		return err
+	}
+
+	return ResetTwoFactor(ctx, app, &user)
+}
+
+// CompleteTwoFactorLogin finishes the pending login of the request with a code from the authenticator app or a
+// recovery code and logs the user in. ErrInvalidToken is returned when there is no pending login or it has expired,
+// after too many wrong codes the pending login ends and the password has to be entered again. Wrong codes count as
+// failed logins, so the same lockout as for passwords applies
+func CompleteTwoFactorLogin(ctx context.Context, app *app.App, w http.ResponseWriter, r *http.Request, code string) (Session, error) {
+	cookie, err := r.Cookie("pending_auth")
+	if err != nil {
+		return Session{}, ErrInvalidToken
+	}
+
+	pending, err := database.From[PendingAuth](app).
+		Where("\"TokenHash\" = ? AND \"ExpiresAt\" > ?", hashToken(cookie.Value), time.Now()).
+		First(database.WithPrimary(ctx))
+	if errors.Is(err, sql.ErrNoRows) {
+		deletePendingAuthCookie(w)
+		return Session{}, ErrInvalidToken
+	}
+	if err != nil {
+		return Session{}, err
+	}
+
+	user, err := UserById(database.WithPrimary(ctx), app, pending.UserId)
+	if err != nil {
+		return Session{}, err
+	}
+
+	client := ClientOf(app, r)
+	guard, err := guardLogin(ctx, app, strings.ToLower(user.Username), client.Ip)
+	if err != nil {
+		return Session{}, err
+	}
+
+	var verifyErr error
+	err = database.WithTransaction(ctx, app, func(ctx context.Context) error {
+		// Lock the pending login so concurrent requests can't each get their own attempts
+		pending, err = database.From[PendingAuth](app).Where("\"Id\" = ?", pending.Id).ForUpdate().First(ctx)
+		if errors.Is(err, sql.ErrNoRows) { // Completed or ended by a concurrent request in the meantime
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		verifyErr = verifySecondFactor(ctx, app, &user, code)
+		if errors.Is(verifyErr, ErrInvalidTwoFactorCode) {
+			pending.Attempts++
+			if pending.Attempts >= pendingAuthMaxAttempts {
+				return database.Delete(ctx, app, &pending)
+			}
+
+			return database.Update(ctx, app, &pending)
+		}
+		if verifyErr != nil {
+			return verifyErr
+		}
+
+		return database.Delete(ctx, app, &pending)
+	})
+	if errors.Is(err, ErrInvalidToken) {
+		guard.discard(ctx, app)
+		deletePendingAuthCookie(w)
+		return Session{}, err
+	}
+	if err != nil {
+		return Session{}, err
+	}
+
+	if verifyErr != nil {
+		slog.Info("incorrect two-factor code", "user", user.Id)
+		guard.failed(app)
+		if pending.Attempts >= pendingAuthMaxAttempts {
+			deletePendingAuthCookie(w)
+		}
+
+		return Session{}, verifyErr
+	}
+
+	deletePendingAuthCookie(w)
+	guard.succeeded(ctx, app)
+
+	return CreateSession(ctx, app, w, user.Id, pending.RememberMe, client)
+}
+
+// beginPendingAuth starts the second login step of the user, the pending login is identified by a cookie
+func beginPendingAuth(ctx context.Context, app *app.App, w http.ResponseWriter, user User, remember bool) error {
+	token := generateAuthToken(app)
+
+	err := database.Insert(ctx, app, &PendingAuth{
+		UserId:     user.Id,
+		TokenHash:  hashToken(token),
+		RememberMe: remember,
+		ExpiresAt:  time.Now().Add(pendingAuthTtl),
+	})
+	if err != nil {
+		slog.Error("error inserting pending auth into database: " + err.Error())
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "pending_auth",
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(pendingAuthTtl / time.Second),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// deletePendingAuthCookie deletes the cookie of the pending login
+func deletePendingAuthCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   "pending_auth",
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+}
+
+// verifySecondFactor checks code against the authenticator secret of the user and otherwise against their unused
+// recovery codes, a matching recovery code is used up and a matching authenticator code can't be used again. The
+// user is read again and locked so of concurrent requests with the same code only one succeeds, call it inside a
+// transaction
+func verifySecondFactor(ctx context.Context, app *app.App, user *User, code string) error {
+	stored, err := database.From[User](app).Where("\"Id\" = ?", user.Id).ForUpdate().First(ctx)
+	if err != nil {
+		return err
+	}
+	*user = stored
+
+	if !user.TwoFactorEnabled() {
+		return ErrTwoFactorDisabled
+	}
+
+	step, ok := checkTotp(string(user.TotpSecret), code, time.Now(), user.TotpLastStep)
+	if ok {
+		user.TotpLastStep = step
+		return database.Update(ctx, app, user)
+	}
+
+	deleted, err := database.From[RecoveryCode](app).Where("\"UserId\" = ? AND \"CodeHash\" = ?", user.Id, hashToken(normalizeRecoveryCode(code))).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+
+	slog.Info("recovery code used", "user", user.Id)
+	return nil
+}
+
+// replaceRecoveryCodes deletes the recovery codes of the user and returns new ones, call it inside a transaction
+func replaceRecoveryCodes(ctx context.Context, app *app.App, userId int64) ([]string, error) {
+	_, err := database.From[RecoveryCode](app).Where("\"UserId\" = ?", userId).Delete(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		err = database.Insert(ctx, app, &RecoveryCode{UserId: userId, CodeHash: hashToken(normalizeRecoveryCode(codes[i]))})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// newRecoveryCode returns a random 80 bit code formatted for reading, e.g. abcd-efgh-ijkl-mnop
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := strings.ToLower(totpEncoding.EncodeToString(b))
+	return encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16], nil
+}
+
+// normalizeRecoveryCode strips the separators and case users may type a recovery code with
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// totpUri returns the otpauth URI authenticator apps read the secret of the user from, usually from a QR code
+func totpUri(app *app.App, user User) string {
+	issuer := app.Config.SiteName
+	if issuer == "" {
+		issuer = "GoWeb"
+	}
+
+	params := url.Values{}
+	params.Set("secret", string(user.TotpSecret))
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", strconv.Itoa(totpDigits))
+	params.Set("period", strconv.Itoa(int(totpPeriod/time.Second)))
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+user.Username) + "?" + params.Encode()
+}
+
+// ScheduledPendingAuthCleanup deletes expired pending logins
+func ScheduledPendingAuthCleanup(app *app.App) {
+	err := database.EachTenant(context.Background(), app, func(ctx context.Context) error {
+		_, err := database.From[PendingAuth](app).Where("\"ExpiresAt\" < ?", time.Now()).Delete(ctx)
+		return err
+	})
+	if err != nil {
+		slog.Error("error deleting expired pending logins from database: " + err.Error())
+		return
+	}
+
+	slog.Info("deleted expired pending logins from database")
+}
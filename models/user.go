@@ -2,118 +2,202 @@ package models
 
 import (
 	"GoWeb/app"
-	"crypto/sha256"
-	"encoding/hex"
+	"GoWeb/database"
+	"context"
+	"database/sql"
+	"errors"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	Id        int64
-	Username  string
-	Password  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	Id              int64
+	Username        string
+	Email           sql.NullString `index:"unique,lower"` // NULL for accounts created before email addresses were required
+	EmailVerifiedAt sql.NullTime
+	Password        string                   `audit:"redact"`
+	TotpSecret      database.EncryptedString // Authenticator secret, set while enrolling and kept once TotpEnabledAt is set
+	TotpEnabledAt   sql.NullTime
+	TotpLastStep    int64 // Step of the last accepted authenticator code, codes can't be used twice
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       sql.NullTime
+
+	Sessions []Session `db:"hasMany,fk=UserId"`                             // Loaded on request, e.g. database.Load(ctx, app, &user, "Sessions")
+	Roles    []Role    `db:"manyToMany,join=UserRole,fk=UserId,ref=RoleId"` // Loaded with their permissions by LoadAccess
 }
 
-const userColumnsNoId = "\"Username\", \"Password\", \"CreatedAt\", \"UpdatedAt\""
-const userColumns = "\"Id\", " + userColumnsNoId
-const userTable = "public.\"User\""
+// AuditEnabled records every change to users in the audit trail
+func (User) AuditEnabled() bool {
+	return true
+}
 
-const (
-	selectUserById       = "SELECT " + userColumns + " FROM " + userTable + " WHERE \"Id\" = $1"
-	selectUserByUsername = "SELECT " + userColumns + " FROM " + userTable + " WHERE \"Username\" = $1"
-	insertUser           = "INSERT INTO " + userTable + " (" + userColumnsNoId + ") VALUES ($1, $2, $3, $4) RETURNING \"Id\""
+var (
+	// ErrUsernameTaken is returned when creating or renaming a user to a username another user already has
+	ErrUsernameTaken = errors.New("username is already taken")
+	// ErrEmailTaken is returned when creating or updating a user with an email address another user already has,
+	// addresses are compared case-insensitively
+	ErrEmailTaken = errors.New("email address is already taken")
+	// ErrInvalidEmail is returned when an email address can't be parsed
+	ErrInvalidEmail = errors.New("email address is invalid")
 )
 
-// CurrentUser finds the currently logged-in user by session cookie
-func CurrentUser(app *app.App, r *http.Request) (User, error) {
-	cookie, err := r.Cookie("session")
+// BeforeCreate rejects usernames and email addresses that are already taken, including by deleted users
+func (u *User) BeforeCreate(ctx context.Context, app *app.App) error {
+	err := u.checkUsername(ctx, app)
 	if err != nil {
-		return User{}, err
+		return err
 	}
 
-	session, err := SessionByAuthToken(app, cookie.Value)
+	return u.checkEmail(ctx, app)
+}
+
+// BeforeUpdate rejects changing the username or email address of the user to one that is already taken
+func (u *User) BeforeUpdate(ctx context.Context, app *app.App) error {
+	err := u.checkUsername(ctx, app)
 	if err != nil {
-		return User{}, err
+		return err
 	}
 
-	return UserById(app, session.UserId)
+	return u.checkEmail(ctx, app)
 }
 
-// UserById finds a User table row in the database by id and returns a struct representing this row
-func UserById(app *app.App, id int64) (User, error) {
-	user := User{}
+// BeforeDelete logs the user out everywhere by deleting their sessions
+func (u *User) BeforeDelete(ctx context.Context, app *app.App) error {
+	_, err := database.From[Session](app).Where("\"UserId\" = ?", u.Id).Delete(ctx)
+	return err
+}
 
-	err := app.Db.QueryRow(selectUserById, id).Scan(&user.Id, &user.Username, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+// checkUsername returns ErrUsernameTaken if another user has the username of u
+func (u *User) checkUsername(ctx context.Context, app *app.App) error {
+	taken, err := database.From[User](app).WithTrashed().Where("\"Username\" = ? AND \"Id\" <> ?", u.Username, u.Id).Exists(ctx)
 	if err != nil {
-		return User{}, err
+		return err
 	}
 
-	return user, nil
+	if taken {
+		return ErrUsernameTaken
+	}
+
+	return nil
 }
 
-// UserByUsername finds a User table row in the database by username and returns a struct representing this row
-func UserByUsername(app *app.App, username string) (User, error) {
-	user := User{}
+// checkEmail returns ErrEmailTaken if another user has the email address of u in any case, the unique index on the
+// column catches concurrent sign-ups this check misses
+func (u *User) checkEmail(ctx context.Context, app *app.App) error {
+	if !u.Email.Valid {
+		return nil
+	}
 
-	err := app.Db.QueryRow(selectUserByUsername, username).Scan(&user.Id, &user.Username, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	taken, err := database.From[User](app).WithTrashed().Where("lower(\"Email\") = lower(?) AND \"Id\" <> ?", u.Email.String, u.Id).Exists(ctx)
+	if err != nil {
+		return err
+	}
+
+	if taken {
+		return ErrEmailTaken
+	}
+
+	return nil
+}
+
+// Verified reports whether the user has confirmed their email address
+func (u User) Verified() bool {
+	return u.EmailVerifiedAt.Valid
+}
+
+// CurrentUser finds the currently logged-in user by session cookie
+func CurrentUser(app *app.App, r *http.Request) (User, error) {
+	session, err := CurrentSession(app, r)
 	if err != nil {
 		return User{}, err
 	}
 
-	return user, nil
+	return UserById(r.Context(), app, session.UserId)
 }
 
-// CreateUser creates a User table row in the database
-func CreateUser(app *app.App, username string, password string, createdAt time.Time, updatedAt time.Time) (User, error) {
-	// Get sha256 hash of password then get bcrypt hash to store
-	hash256 := sha256.New()
-	hash256.Write([]byte(password))
-	hashSum := hash256.Sum(nil)
-	hashString := hex.EncodeToString(hashSum)
-	hash, err := bcrypt.GenerateFromPassword([]byte(hashString), bcrypt.DefaultCost)
+// UserById finds a User table row in the database by id and returns a struct representing this row
+func UserById(ctx context.Context, app *app.App, id int64) (User, error) {
+	return database.Find[User](ctx, app, id)
+}
+
+// UserByUsername finds a User table row in the database by username and returns a struct representing this row
+func UserByUsername(ctx context.Context, app *app.App, username string) (User, error) {
+	return database.From[User](app).Where("\"Username\" = ?", username).First(ctx)
+}
+
+// UserByEmail finds a User table row in the database by email address, ignoring case
+func UserByEmail(ctx context.Context, app *app.App, email string) (User, error) {
+	return database.From[User](app).Where("lower(\"Email\") = lower(?)", email).First(ctx)
+}
+
+// CreateUser creates a User table row in the database, the email address starts out unverified
+func CreateUser(ctx context.Context, app *app.App, username string, email string, password string) (User, error) {
+	address, err := normalizeEmail(email)
+	if err != nil {
+		return User{}, err
+	}
+
+	hash, err := hashPassword(password)
 	if err != nil {
-		slog.Error("error hashing password: " + err.Error())
 		return User{}, err
 	}
 
-	var lastInsertId int64
+	user := User{
+		Username: username,
+		Email:    sql.NullString{String: address, Valid: true},
+		Password: hash,
+	}
 
-	err = app.Db.QueryRow(insertUser, username, string(hash), createdAt, updatedAt).Scan(&lastInsertId)
+	err = database.Insert(ctx, app, &user)
 	if err != nil {
 		slog.Error("error creating user row: " + err.Error())
 		return User{}, err
 	}
 
-	return UserById(app, lastInsertId)
+	return user, nil
 }
 
-// AuthenticateUser validates the password for the specified user
-func AuthenticateUser(app *app.App, w http.ResponseWriter, username string, password string, remember bool) (Session, error) {
-	var user User
+// AuthenticateUser validates the password for the specified user and logs them in, users with two-factor
+// authentication enabled get a pending login instead and ErrTwoFactorRequired is returned, see CompleteTwoFactorLogin.
+// Failed logins slow down further attempts for the username and IP address and eventually lock them out with
+// ErrTooManyLoginAttempts, unknown usernames and wrong passwords both return ErrInvalidCredentials
+func AuthenticateUser(ctx context.Context, app *app.App, w http.ResponseWriter, username string, password string, remember bool, client Client) (Session, error) {
+	guard, err := guardLogin(ctx, app, strings.ToLower(username), client.Ip)
+	if err != nil {
+		return Session{}, err
+	}
 
-	err := app.Db.QueryRow(selectUserByUsername, username).Scan(&user.Id, &user.Username, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	user, err := UserByUsername(ctx, app, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		guard.failed(app)
+		return Session{}, rejectUnknownUser(password)
+	}
 	if err != nil {
-		slog.Info("user not found: " + username)
 		return Session{}, err
 	}
 
-	// Get sha256 hash of password then check bcrypt
-	hash256 := sha256.New()
-	hash256.Write([]byte(password))
-	hashSum := hash256.Sum(nil)
-	hashString := hex.EncodeToString(hashSum)
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(hashString))
+	err = checkPassword(user.Password, password)
 	if err != nil { // Failed to validate password, doesn't match
-		slog.Info("incorrect password:" + username)
-		return Session{}, err
-	} else {
-		return CreateSession(app, w, user.Id, remember)
+		guard.failed(app)
+		return Session{}, ErrInvalidCredentials
+	}
+
+	if user.TwoFactorEnabled() { // Only counts as a successful login once the second factor is checked too
+		guard.discard(ctx, app)
+		err = beginPendingAuth(ctx, app, w, user, remember)
+		if err != nil {
+			return Session{}, err
+		}
+
+		return Session{}, ErrTwoFactorRequired
 	}
+
+	guard.succeeded(ctx, app)
+
+	return CreateSession(ctx, app, w, user.Id, remember, client)
 }
 
 // LogoutUser deletes the session cookie and AuthToken from the database
@@ -123,7 +207,7 @@ func LogoutUser(app *app.App, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = DeleteSessionByAuthToken(app, w, cookie.Value)
+	err = DeleteSessionByAuthToken(r.Context(), app, w, cookie.Value)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"GoWeb/mail"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// emailVerificationTtl is how long an emailed verification link works
+	emailVerificationTtl = 48 * time.Hour
+	// verificationResendInterval is how long a user has to wait before another verification email is sent
+	verificationResendInterval = 5 * time.Minute
+)
+
+var (
+	// ErrAlreadyVerified is returned when sending a verification email to a user whose address is already verified
+	ErrAlreadyVerified = errors.New("email address is already verified")
+	// ErrVerificationThrottled is returned when a verification email was sent too recently to send another one
+	ErrVerificationThrottled = errors.New("verification email was sent recently, try again later")
+)
+
+// SendEmailVerification emails a link confirming the email address of the user, it returns ErrVerificationThrottled
+// if the previous one was sent less than verificationResendInterval ago so the resend button can't be used to spam
+// the address
+func SendEmailVerification(ctx context.Context, app *app.App, user User) error {
+	if user.Verified() {
+		return ErrAlreadyVerified
+	}
+
+	if !user.Email.Valid {
+		return ErrInvalidEmail
+	}
+
+	recent, err := database.From[UserToken](app).
+		Where("\"UserId\" = ? AND \"Purpose\" = ? AND \"CreatedAt\" > ?", user.Id, TokenEmailVerification, time.Now().Add(-verificationResendInterval)).
+		Exists(database.WithPrimary(ctx))
+	if err != nil {
+		return err
+	}
+
+	if recent {
+		return ErrVerificationThrottled
+	}
+
+	token, err := CreateUserToken(ctx, app, user.Id, TokenEmailVerification, emailVerificationTtl)
+	if err != nil {
+		return err
+	}
+
+	link := siteUrl(app, "/verify-email?token="+url.QueryEscape(token))
+	return app.Mailer.Send(ctx, mail.Message{
+		To:      user.Email.String,
+		Subject: "Confirm your email address",
+		Body: "Welcome " + user.Username + "! Open the link below within two days to confirm your email address.\n\n" +
+			link + "\n",
+	})
+}
+
+// VerifyEmail marks the email address of the user a verification token was sent to as verified, the token is used
+// up. ErrInvalidToken is returned for unknown or expired tokens
+func VerifyEmail(ctx context.Context, app *app.App, token string) (User, error) {
+	var user User
+	err := database.WithTransaction(ctx, app, func(ctx context.Context) error {
+		userToken, err := ConsumeUserToken(ctx, app, TokenEmailVerification, token)
+		if err != nil {
+			return err
+		}
+
+		user, err = UserById(ctx, app, userToken.UserId)
+		if err != nil {
+			return err
+		}
+
+		if user.Verified() {
+			return nil
+		}
+
+		user.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		err = database.Update(ctx, app, &user)
+		if err != nil {
+			return err
+		}
+
+		slog.Info("email address verified", "user", user.Id)
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// normalizeEmail checks that email is a single bare address and returns it without surrounding spaces
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+
+	address, err := netmail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+
+	return email, nil
+}
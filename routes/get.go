@@ -3,6 +3,8 @@ package routes
 import (
 	"GoWeb/app"
 	"GoWeb/controllers"
+	"GoWeb/middleware"
+	"GoWeb/models"
 	"io/fs"
 	"log/slog"
 	"net/http"
@@ -30,4 +32,19 @@ func Get(app *app.App) {
 	http.HandleFunc("/login", getController.ShowLogin)
 	http.HandleFunc("/register", getController.ShowRegister)
 	http.HandleFunc("/logout", getController.Logout)
+	http.HandleFunc("/forgot-password", getController.ShowForgotPassword)
+	http.HandleFunc("/reset-password", getController.ShowResetPassword)
+	http.HandleFunc("/verify-email", getController.VerifyEmail)
+	http.HandleFunc("/sessions", middleware.Auth(app, getController.ShowSessions))
+
+	// Two-factor authentication, its secrets can only be stored once encryption keys are configured
+	if models.TwoFactorAvailable() {
+		http.HandleFunc("/two-factor-login", getController.ShowTwoFactorLogin)
+		http.HandleFunc("/two-factor", middleware.Auth(app, getController.ShowTwoFactor))
+	} else {
+		slog.Warn("two-factor authentication is turned off until EncryptionKeys are configured")
+	}
+
+	// Administration
+	http.HandleFunc("/admin", middleware.Permission(app, "users.manage", getController.ShowAdmin))
 }
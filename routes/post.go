@@ -4,6 +4,7 @@ import (
 	"GoWeb/app"
 	"GoWeb/controllers"
 	"GoWeb/middleware"
+	"GoWeb/models"
 	"net/http"
 )
 
@@ -17,4 +18,24 @@ func Post(app *app.App) {
 	// User authentication
 	http.HandleFunc("/register-handle", middleware.Csrf(postController.Register))
 	http.HandleFunc("/login-handle", middleware.Csrf(postController.Login))
+	http.HandleFunc("/forgot-password-handle", middleware.Csrf(postController.ForgotPassword))
+	http.HandleFunc("/reset-password-handle", middleware.Csrf(postController.ResetPassword))
+	http.HandleFunc("/resend-verification-handle", middleware.Csrf(middleware.Auth(app, postController.ResendVerification)))
+
+	// Two-factor authentication, its secrets can only be stored once encryption keys are configured
+	if models.TwoFactorAvailable() {
+		http.HandleFunc("/two-factor-login-handle", middleware.Csrf(postController.TwoFactorLogin))
+		http.HandleFunc("/two-factor-setup-handle", middleware.Csrf(middleware.Auth(app, postController.SetupTwoFactor)))
+		http.HandleFunc("/two-factor-enable-handle", middleware.Csrf(middleware.Auth(app, postController.EnableTwoFactor)))
+		http.HandleFunc("/two-factor-recovery-handle", middleware.Csrf(middleware.Auth(app, postController.RegenerateRecoveryCodes)))
+		http.HandleFunc("/two-factor-disable-handle", middleware.Csrf(middleware.Auth(app, postController.DisableTwoFactor)))
+	}
+
+	// Devices and password
+	http.HandleFunc("/sessions-revoke-handle", middleware.Csrf(middleware.Auth(app, postController.RevokeSession)))
+	http.HandleFunc("/sessions-revoke-others-handle", middleware.Csrf(middleware.Auth(app, postController.RevokeOtherSessions)))
+	http.HandleFunc("/change-password-handle", middleware.Csrf(middleware.Auth(app, postController.ChangePassword)))
+
+	// Administration
+	http.HandleFunc("/admin-reset-two-factor-handle", middleware.Csrf(middleware.Permission(app, "users.manage", postController.ResetUserTwoFactor)))
 }
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"GoWeb/app"
+	"GoWeb/controllers"
+	"net/http"
+)
+
+// Probes defines the routes for load balancers and orchestrators on mux, which is served outside the tenant middleware
+func Probes(app *app.App, mux *http.ServeMux) {
+	getController := controllers.Get{
+		App: app,
+	}
+
+	// Readiness probe
+	mux.HandleFunc("/health", getController.Health)
+}
@@ -0,0 +1,33 @@
+package security
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIp returns the IP address of the client making the request. Behind reverse proxies every request comes from
+// the last proxy, name the header they add the client address to (e.g. X-Forwarded-For) in header and how many
+// proxies append to it in proxies to use that instead. Proxies append the address they received the request from, so
+// the client is counted from the right: entries further left were sent by the client and can be anything
+func ClientIp(r *http.Request, header string, proxies int) string {
+	if header != "" {
+		// Proxies may add their entry as another header line rather than extending the last one
+		if value := strings.Join(r.Header.Values(header), ","); value != "" {
+			entries := strings.Split(value, ",")
+			i := len(entries) - max(proxies, 1)
+			if i < 0 { // Fewer entries than proxies, all of them were added by a proxy
+				i = 0
+			}
+
+			return strings.TrimSpace(entries[i])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,34 @@
+package security
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIp(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		proxies int
+		want    string
+	}{
+		{"no header", nil, 1, "203.0.113.9"},
+		{"one proxy", []string{"198.51.100.1"}, 1, "198.51.100.1"},
+		{"spoofed entry", []string{"10.0.0.1, 198.51.100.1"}, 1, "198.51.100.1"},
+		{"two proxies", []string{"10.0.0.1, 198.51.100.1, 192.0.2.7"}, 2, "198.51.100.1"},
+		{"proxy adds a header line", []string{"10.0.0.1", "198.51.100.1"}, 1, "198.51.100.1"},
+		{"fewer entries than proxies", []string{"198.51.100.1"}, 2, "198.51.100.1"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "203.0.113.9:1234"
+		for _, line := range test.lines {
+			r.Header.Add("X-Forwarded-For", line)
+		}
+
+		if got := ClientIp(r, "X-Forwarded-For", test.proxies); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
@@ -11,6 +11,48 @@ import (
 
 var templates = make(map[string]*template.Template) // This is only used here, does not need to be in app.App
 
+// Viewer is the logged-in user a page is shown to. The caller works out what they may do, e.g. with models.Can, so
+// templates don't depend on how roles are stored
+type Viewer struct {
+	Username  string
+	TwoFactor bool // Whether two-factor authentication can be set up
+	Can       func(permission string) bool
+	HasRole   func(role string) bool
+}
+
+// Page is embedded in the data of pages to show the base template and helpers who is logged in, Viewer is nil for
+// visitors who aren't
+type Page struct {
+	Viewer *Viewer
+}
+
+func (p Page) pageViewer() *Viewer {
+	return p.Viewer
+}
+
+// funcs are the helper functions available in every template, e.g. {{ if can $ "users.manage" }} hides links the
+// user can't use. They take the data of the page, which is treated as logged out unless it embeds Page
+var funcs = template.FuncMap{
+	"viewer": viewerOf,
+	"can": func(data any, permission string) bool {
+		v := viewerOf(data)
+		return v != nil && v.Can != nil && v.Can(permission)
+	},
+	"hasRole": func(data any, role string) bool {
+		v := viewerOf(data)
+		return v != nil && v.HasRole != nil && v.HasRole(role)
+	},
+}
+
+// viewerOf returns the logged-in user of page data embedding Page, nil for anything else
+func viewerOf(data any) *Viewer {
+	if page, ok := data.(interface{ pageViewer() *Viewer }); ok {
+		return page.pageViewer()
+	}
+
+	return nil
+}
+
 func BuildPages(app *app.App) error {
 	basePath := app.Config.Template.BaseName
 
@@ -19,7 +61,7 @@ func BuildPages(app *app.App) error {
 		return fmt.Errorf("error reading base file: %w", err)
 	}
 
-	base, err := template.New(basePath).Parse(string(baseContent)) // Sets filepath as name and parses content
+	base, err := template.New(basePath).Funcs(funcs).Parse(string(baseContent)) // Sets filepath as name and parses content
 	if err != nil {
 		return fmt.Errorf("error parsing base file: %w", err)
 	}
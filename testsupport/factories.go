@@ -0,0 +1,54 @@
+package testsupport
+
+import (
+	"GoWeb/app"
+	"GoWeb/database"
+	"GoWeb/models"
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// DefaultPassword is the password of every user created by NewUser
+const DefaultPassword = "password"
+
+var sequence atomic.Int64 // Keeps generated usernames unique across tests
+
+// NewUser creates a user with a unique username and email address and DefaultPassword, changes made by the optional
+// modifiers are saved before the user is returned
+func NewUser(t testing.TB, ctx context.Context, app *app.App, modifiers ...func(user *models.User)) models.User {
+	t.Helper()
+
+	username := "user" + strconv.FormatInt(sequence.Add(1), 10)
+	user, err := models.CreateUser(ctx, app, username, username+"@example.com", DefaultPassword)
+	if err != nil {
+		t.Fatal("error creating test user: " + err.Error())
+	}
+
+	if len(modifiers) > 0 {
+		for _, modify := range modifiers {
+			modify(&user)
+		}
+
+		err = database.Update(ctx, app, &user)
+		if err != nil {
+			t.Fatal("error updating test user: " + err.Error())
+		}
+	}
+
+	return user
+}
+
+// NewSession creates a session for the user, as if they had just logged in
+func NewSession(t testing.TB, ctx context.Context, app *app.App, user models.User, remember bool) models.Session {
+	t.Helper()
+
+	session, err := models.CreateSession(ctx, app, httptest.NewRecorder(), user.Id, remember, models.Client{Ip: "127.0.0.1", UserAgent: "testsupport"})
+	if err != nil {
+		t.Fatal("error creating test session: " + err.Error())
+	}
+
+	return session
+}
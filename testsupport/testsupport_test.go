@@ -0,0 +1,66 @@
+package testsupport_test
+
+import (
+	"GoWeb/models"
+	"GoWeb/testsupport"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	testsupport.Main(m)
+}
+
+func TestTxRollsBackWhenTheTestEnds(t *testing.T) {
+	var username string
+	t.Run("create", func(t *testing.T) {
+		ctx, app := testsupport.Tx(t)
+		username = testsupport.NewUser(t, ctx, app).Username
+
+		_, err := models.UserByUsername(ctx, app, username)
+		if err != nil {
+			t.Fatalf("user not visible inside its transaction: %v", err)
+		}
+	})
+
+	ctx, app := testsupport.Tx(t)
+	_, err := models.UserByUsername(ctx, app, username)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got %v, want the user of the finished test rolled back", err)
+	}
+}
+
+func TestNewUserIsUniqueAndSavesModifiers(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	first := testsupport.NewUser(t, ctx, app)
+	second := testsupport.NewUser(t, ctx, app, func(user *models.User) {
+		user.EmailVerifiedAt = sql.NullTime{Time: user.CreatedAt, Valid: true}
+	})
+
+	if first.Username == second.Username || first.Email == second.Email {
+		t.Fatalf("got %q and %q, want unique users", first.Username, second.Username)
+	}
+
+	stored, err := models.UserById(ctx, app, second.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Verified() {
+		t.Fatal("change made by the modifier was not saved")
+	}
+}
+
+func TestNewSessionAuthenticates(t *testing.T) {
+	ctx, app := testsupport.Tx(t)
+	user := testsupport.NewUser(t, ctx, app)
+	session := testsupport.NewSession(t, ctx, app, user, true)
+
+	stored, err := models.SessionByAuthToken(ctx, app, session.AuthToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.UserId != user.Id || !stored.RememberMe {
+		t.Fatalf("got session of user %d remembered %v, want user %d remembered", stored.UserId, stored.RememberMe, user.Id)
+	}
+}